@@ -0,0 +1,75 @@
+package aria2
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	aria2V1370Bep20 = "A2-1-37-0-"
+)
+
+var (
+	// aria2 enables gzip for tracker requests, and always sends the no-cache headers.
+	headers = []commons.Header{
+		{Name: "User-Agent", Value: "aria2/1.37.0"},
+		{Name: "Accept", Value: "*/*"},
+		{Name: "Pragma", Value: "no-cache"},
+		{Name: "Cache-Control", Value: "no-cache"},
+		{Name: "Accept-Encoding", Value: "deflate, gzip"},
+	}
+
+	// aria2 announces in the same fixed order and format as aria2 1.37.0. aria2
+	// does not want peers when halting.
+	//
+	// https://github.com/aria2/aria2/blob/release-1.37.0/src/DefaultBtAnnounce.cc
+	aria2 = &commons.ClientDef{
+		Name: "aria2",
+		Client: commons.ClientIdentity{
+			Bep20:                          aria2V1370Bep20,
+			ExtendedHandshakeClientVersion: "aria2/1.37.0",
+			HTTPUserAgent:                  headers[0].Value,
+			UpnpID:                         "aria2",
+		},
+		Headers: headers,
+		QueryDefs: []*commons.QueryDef{
+			commons.MustHaveDef("info_hash"),
+			commons.MustHaveDef("peer_id"),
+			commons.MustHaveDef("uploaded"),
+			commons.MustHaveDef("downloaded"),
+			commons.MustHaveDef("left"),
+			commons.MustHaveDef("compact"),
+			commons.MustHaveDef("key"),
+			commons.MustHaveDef("numwant"),
+			commons.FixedDef("no_peer_id", "1"),
+			commons.MustHaveDef("port"),
+			commons.OptionalDef("event"),
+			commons.OptionalDef("trackerid"),
+			commons.MustHaveDef("supportcrypto"),
+		},
+		NumWant:     commons.NumWant{Announce: 50, Stopped: 0},
+		Scopes:      commons.Scopes{PeerID: commons.ScopeSession, Key: commons.ScopeSession},
+		NewIdentity: createPerTorrent,
+	}
+)
+
+// New creates a director announcing as aria2 1.37.0.
+func New() *commons.ClientDirector {
+	s, err := commons.NewClientDirector(aria2)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func createPerTorrent() *commons.Identity {
+	// peer_id is "A2-1-37-0-" + 10 random bytes. Per session.
+	// See ConfigureClient.
+
+	// key is the last 8 bytes of peer_id, percent-encoded with the other
+	// params. Per session.
+	peerID := aria2V1370Bep20 + string(commons.RandomBytes(10))
+	return &commons.Identity{
+		PeerID: peerID,
+		Key:    peerID[12:20],
+	}
+}
@@ -0,0 +1,61 @@
+package aria2
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnounce(t *testing.T) {
+	rd := New()
+	cfg := torrent.NewDefaultClientConfig()
+	rd.ConfigureClient(cfg)
+	assert.Equal(t, aria2V1370Bep20, cfg.Bep20)
+	assert.Equal(t, "aria2/1.37.0", cfg.ExtendedHandshakeClientVersion)
+	assert.Equal(t, "aria2", cfg.UpnpID)
+
+	testCases := []struct {
+		event   string
+		numwant string
+	}{
+		{event: "started", numwant: "50"},
+		{event: "stopped", numwant: "0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.event, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&event="+tc.event+"&info_hash=123&key=1&left=0&peer_id=OLD&port=3456&supportcrypto=1&uploaded=0", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+			require.NoError(t, rd.ChangeHttpRequest(req))
+
+			assert.Equal(t, http.Header{
+				"User-Agent":      {"aria2/1.37.0"},
+				"Accept":          {"*/*"},
+				"Pragma":          {"no-cache"},
+				"Cache-Control":   {"no-cache"},
+				"Accept-Encoding": {"deflate, gzip"},
+			}, req.Header)
+
+			q, err := commons.QueryParamsFromRawQueryStr(req.URL.RawQuery)
+			require.NoError(t, err)
+			var names []string
+			for _, p := range q {
+				names = append(names, p.Name)
+			}
+			assert.Equal(t, []string{"info_hash", "peer_id", "uploaded", "downloaded", "left", "compact", "key", "numwant", "no_peer_id", "port", "event", "supportcrypto"}, names)
+
+			v := req.URL.Query()
+			assert.Equal(t, tc.numwant, v.Get("numwant"))
+			assert.Equal(t, "1", v.Get("no_peer_id"))
+			assert.True(t, strings.HasPrefix(v.Get("peer_id"), aria2V1370Bep20))
+			assert.Equal(t, cfg.PeerID, v.Get("peer_id"), "peer_id is per session")
+			assert.Equal(t, v.Get("peer_id")[12:20], v.Get("key"), "key is the peer_id suffix")
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package aria2
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+func init() {
+	info := commons.ProfileInfo{
+		Client:    "aria2",
+		Version:   "1.37.0",
+		Engine:    "aria2",
+		Released:  "2023-11",
+		Protocols: []string{commons.ProtocolHTTP, commons.ProtocolHTTPS, commons.ProtocolUDP},
+	}
+	commons.RegisterProfile(info, func() (commons.Director, error) {
+		return New(), nil
+	})
+}
@@ -0,0 +1,86 @@
+package biglybt
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	biglybtV3500Bep20 = "-BI3500-"
+
+	// Azureus / BiglyBT random charset for peer_id and key.
+	charSet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+	// azver is the Azureus announce protocol version.
+	azver = "3"
+)
+
+var (
+	// BiglyBT announces with Java HttpURLConnection, the User-Agent is
+	// "<client> <version>;<os>;Java <java version>", and Accept is the Java default.
+	headers = []commons.Header{
+		{Name: "User-Agent", Value: "BiglyBT 3.5.0.0;Windows 10;Java 17.0.8.1"},
+		{Name: "Accept-Encoding", Value: "gzip"},
+		{Name: "Accept", Value: "text/html, image/gif, image/jpeg, *; q=.2, */*; q=.2"},
+	}
+
+	// biglybt announces in the same fixed order and format as the BiglyBT 3.5
+	// (Vuze / Azureus fork) BitTorrent client.
+	//
+	// BiglyBT announces to all trackers in all tiers, which is what anacrolix/torrent
+	// does, so there is nothing to change on which trackers get the announces.
+	// BiglyBT does not want peers when stopping. anacrolix/torrent uses the same
+	// port for TCP and UDP, "azudp" is the "port", and does not track corrupt bytes.
+	//
+	// https://github.com/BiglySoftware/BiglyBT/blob/master/core/src/com/biglybt/core/tracker/client/impl/bt/TRTrackerBTAnnouncerImpl.java
+	biglybt = &commons.ClientDef{
+		Name: "biglybt",
+		Client: commons.ClientIdentity{
+			Bep20:                          biglybtV3500Bep20,
+			ExtendedHandshakeClientVersion: "BiglyBT 3.5.0.0",
+			HTTPUserAgent:                  headers[0].Value,
+			UpnpID:                         "BiglyBT",
+		},
+		Headers: headers,
+		QueryDefs: []*commons.QueryDef{
+			commons.MustHaveDef("info_hash"),
+			commons.MustHaveDef("peer_id"),
+			commons.MustHaveDef("supportcrypto"),
+			commons.MustHaveDef("port"),
+			commons.CopyDef("azudp", "port"),
+			commons.MustHaveDef("uploaded"),
+			commons.MustHaveDef("downloaded"),
+			commons.MustHaveDef("left"),
+			commons.FixedDef("corrupt", "0"),
+			commons.OptionalDef("event"),
+			commons.MustHaveDef("numwant"),
+			commons.FixedDef("no_peer_id", "1"),
+			commons.MustHaveDef("compact"),
+			commons.MustHaveDef("key"),
+			commons.FixedDef("azver", azver),
+			commons.FixedDef("azq", "1"),
+		},
+		NumWant:     commons.NumWant{Announce: 100, Stopped: 0},
+		Scopes:      commons.Scopes{PeerID: commons.ScopeSession, Key: commons.ScopeTorrent},
+		NewIdentity: createPerTorrent,
+	}
+)
+
+// New creates a director announcing as BiglyBT 3.5.
+func New() *commons.ClientDirector {
+	s, err := commons.NewClientDirector(biglybt)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func createPerTorrent() *commons.Identity {
+	// peer_id is "-BI3500-" + 12 random alphanumeric chars. Per session.
+	// See ConfigureClient.
+
+	// key is 8 random alphanumeric chars. Per torrent.
+	return &commons.Identity{
+		PeerID: biglybtV3500Bep20 + commons.RandomString(charSet, 12),
+		Key:    commons.RandomString(charSet, 8),
+	}
+}
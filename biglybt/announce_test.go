@@ -0,0 +1,63 @@
+package biglybt
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnounce(t *testing.T) {
+	rd := New()
+	cfg := torrent.NewDefaultClientConfig()
+	rd.ConfigureClient(cfg)
+	assert.Equal(t, biglybtV3500Bep20, cfg.Bep20)
+	assert.Equal(t, "BiglyBT 3.5.0.0", cfg.ExtendedHandshakeClientVersion)
+	assert.Equal(t, "BiglyBT", cfg.UpnpID)
+
+	testCases := []struct {
+		event   string
+		numwant string
+	}{
+		{event: "started", numwant: "100"},
+		{event: "stopped", numwant: "0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.event, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&event="+tc.event+"&info_hash=123&key=1&left=0&peer_id=OLD&port=3456&supportcrypto=1&uploaded=0", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+			require.NoError(t, rd.ChangeHttpRequest(req))
+
+			assert.Equal(t, http.Header{
+				"User-Agent":      {"BiglyBT 3.5.0.0;Windows 10;Java 17.0.8.1"},
+				"Accept-Encoding": {"gzip"},
+				"Accept":          {"text/html, image/gif, image/jpeg, *; q=.2, */*; q=.2"},
+			}, req.Header)
+
+			q, err := commons.QueryParamsFromRawQueryStr(req.URL.RawQuery)
+			require.NoError(t, err)
+			var names []string
+			for _, p := range q {
+				names = append(names, p.Name)
+			}
+			assert.Equal(t, []string{"info_hash", "peer_id", "supportcrypto", "port", "azudp", "uploaded", "downloaded", "left", "corrupt", "event", "numwant", "no_peer_id", "compact", "key", "azver", "azq"}, names)
+
+			v := req.URL.Query()
+			assert.Equal(t, tc.numwant, v.Get("numwant"))
+			assert.Equal(t, "3456", v.Get("azudp"))
+			assert.Equal(t, "0", v.Get("corrupt"))
+			assert.Equal(t, "1", v.Get("no_peer_id"))
+			assert.Equal(t, "3", v.Get("azver"))
+			assert.Equal(t, "1", v.Get("azq"))
+			assert.True(t, strings.HasPrefix(v.Get("peer_id"), biglybtV3500Bep20))
+			assert.Equal(t, cfg.PeerID, v.Get("peer_id"), "peer_id is per session")
+			assert.Regexp(t, `^[a-zA-Z0-9]{8}$`, v.Get("key"))
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package biglybt
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+func init() {
+	info := commons.ProfileInfo{
+		Client:    "biglybt",
+		Version:   "3.5.0.0",
+		Engine:    "Azureus",
+		Protocols: []string{commons.ProtocolHTTP, commons.ProtocolHTTPS, commons.ProtocolUDP},
+	}
+	commons.RegisterProfile(info, func() (commons.Director, error) {
+		return New(), nil
+	})
+}
@@ -0,0 +1,159 @@
+package commons
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	EventCompleted = "completed"
+)
+
+// IsScrape reports whether the request is a scrape request instead of an announce.
+func IsScrape(u *url.URL) bool {
+	parts := strings.Split(u.Path, "/")
+	return parts[len(parts)-1] == "scrape"
+}
+
+// announceParams are the announce params of BitTorrent clients. anacrolix/torrent
+// adds some of them to the announce URL, all other params are the tracker's own.
+var announceParams = map[string]bool{
+	"info_hash":     true,
+	"peer_id":       true,
+	"port":          true,
+	"uploaded":      true,
+	"downloaded":    true,
+	"left":          true,
+	"corrupt":       true,
+	"redundant":     true,
+	"key":           true,
+	"event":         true,
+	"numwant":       true,
+	"compact":       true,
+	"no_peer_id":    true,
+	"supportcrypto": true,
+	"requirecrypto": true,
+	"trackerid":     true,
+	"ip":            true,
+	"ipv4":          true,
+	"ipv6":          true,
+}
+
+// TrackerQuery is the tracker's own query in the announce URL, like passkey.
+// Before and After are the raw params before and after the announce params,
+// kept exactly as the tracker gave them.
+type TrackerQuery struct {
+	Before string
+	After  string
+}
+
+// SplitAnnounceQuery splits the announce RawQuery into the tracker's own query
+// and the announce params. anacrolix/torrent appends its params to the tracker's
+// query, but the tracker's params are found by name, not by position. Tracker
+// params between announce params are kept in Before.
+func SplitAnnounceQuery(rawQuery string) (TrackerQuery, url.Values) {
+	var before, after, params []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil {
+			name = unescaped
+		}
+		switch {
+		case announceParams[name]:
+			params = append(params, pair)
+			// Tracker params seen between announce params.
+			before = append(before, after...)
+			after = nil
+		case len(params) == 0:
+			before = append(before, pair)
+		default:
+			after = append(after, pair)
+		}
+	}
+
+	// Same as url.URL.Query(), malformed params are dropped.
+	q, _ := url.ParseQuery(strings.Join(params, "&"))
+	return TrackerQuery{Before: strings.Join(before, "&"), After: strings.Join(after, "&")}, q
+}
+
+// Join puts the client's own query between the tracker's query, the same way
+// clients append their params to the announce URL.
+func (t TrackerQuery) Join(query string) string {
+	res := query
+	if t.Before != "" {
+		res = t.Before + "&" + res
+	}
+	if t.After != "" {
+		res = res + "&" + t.After
+	}
+	return res
+}
+
+// AnnounceURL returns the announce URL without query.
+func AnnounceURL(u *url.URL) string {
+	urlCopy := *u
+	urlCopy.RawQuery = ""
+	return urlCopy.String()
+}
+
+// PerTrackerTorrentID returns the ID of a torrent on a tracker.
+func PerTrackerTorrentID(u *url.URL, infoHash string) string {
+	return AnnounceURL(u) + "--" + infoHash
+}
+
+// onDialKey is the context key of the OnDial hook of a request.
+type onDialKey struct{}
+
+// OnDial sets fn to be called with the connection r is sent over, after it is
+// dialed and before r is written. Profiles use it for announce params depending
+// on the connection, e.g. the address family of a tracker hostname. Only the
+// dialer set up by camouflagetorrentclients.Install calls it.
+func OnDial(r *http.Request, fn func(conn net.Conn)) {
+	*r = *r.WithContext(context.WithValue(r.Context(), onDialKey{}, fn))
+}
+
+// DialHook returns the OnDial hook of the request of ctx, nil if none.
+func DialHook(ctx context.Context) func(conn net.Conn) {
+	fn, _ := ctx.Value(onDialKey{}).(func(conn net.Conn))
+	return fn
+}
+
+// Header is a HTTP header sent by a client.
+type Header struct {
+	Name  string `json:"name" yaml:"name"`
+	Value string `json:"value" yaml:"value"`
+}
+
+// ReplaceHeaders removes all existing headers of the request and sets the
+// given ones.
+func ReplaceHeaders(r *http.Request, headers []Header) {
+	for k := range r.Header {
+		delete(r.Header, k)
+	}
+	for _, h := range headers {
+		r.Header.Set(h.Name, h.Value)
+	}
+}
+
+// ScrapeURL returns the scrape URL of the torrent on the tracker, nil if the
+// tracker does not support scrape. The tracker's own query is kept around
+// info_hash, its own path, like a passkey in path, is kept as well.
+func ScrapeURL(announceURL *url.URL, infoHash string, trackerQuery TrackerQuery) *url.URL {
+	// path does not ending with /announce means this tracker does not support scrape.
+	if !strings.HasSuffix(announceURL.Path, "/announce") {
+		return nil
+	}
+	scrapeURL := announceURL.JoinPath("../scrape")
+
+	query := url.Values{}
+	query.Add("info_hash", infoHash)
+	scrapeURL.RawQuery = trackerQuery.Join(query.Encode())
+
+	return scrapeURL
+}
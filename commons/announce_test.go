@@ -0,0 +1,222 @@
+package commons
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsScrape(t *testing.T) {
+	announce, _ := url.Parse("http://example.com/tracker/announce?info_hash=123")
+	scrape, _ := url.Parse("http://example.com/tracker/scrape?info_hash=123")
+	assert.False(t, IsScrape(announce))
+	assert.True(t, IsScrape(scrape))
+}
+
+func TestSplitAnnounceQuery(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rawQuery string
+		want     TrackerQuery
+		wantKeys []string
+	}{
+		{
+			name:     "no tracker query",
+			rawQuery: "compact=1&downloaded=0&info_hash=123",
+			wantKeys: []string{"compact", "downloaded", "info_hash"},
+		},
+		{
+			name:     "tracker query before",
+			rawQuery: "auth=123&uid=9&compact=1&downloaded=0",
+			want:     TrackerQuery{Before: "auth=123&uid=9"},
+			wantKeys: []string{"compact", "downloaded"},
+		},
+		{
+			name:     "tracker query after",
+			rawQuery: "compact=1&downloaded=0&passkey=abc",
+			want:     TrackerQuery{After: "passkey=abc"},
+			wantKeys: []string{"compact", "downloaded"},
+		},
+		{
+			name:     "tracker query before and after",
+			rawQuery: "passkey=abc&compact=1&downloaded=0&uid=9",
+			want:     TrackerQuery{Before: "passkey=abc", After: "uid=9"},
+			wantKeys: []string{"compact", "downloaded"},
+		},
+		{
+			name:     "passkey contains compact",
+			rawQuery: "passkey=x%26compact%3D1&compact=1&downloaded=0",
+			want:     TrackerQuery{Before: "passkey=x%26compact%3D1"},
+			wantKeys: []string{"compact", "downloaded"},
+		},
+		{
+			name:     "passkey named compact",
+			rawQuery: "compactkey=compact&compact=1&downloaded=0",
+			want:     TrackerQuery{Before: "compactkey=compact"},
+			wantKeys: []string{"compact", "downloaded"},
+		},
+		{
+			name:     "announce params not in anacrolix order",
+			rawQuery: "info_hash=123&passkey=abc&compact=1&peer_id=1",
+			want:     TrackerQuery{Before: "passkey=abc"},
+			wantKeys: []string{"compact", "info_hash", "peer_id"},
+		},
+		{
+			name:     "tracker query kept exactly",
+			rawQuery: "b=2&a=%7E1+&compact=1",
+			want:     TrackerQuery{Before: "b=2&a=%7E1+"},
+			wantKeys: []string{"compact"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, q := SplitAnnounceQuery(tc.rawQuery)
+			assert.Equal(t, tc.want, got)
+			keys := []string{}
+			for k := range q {
+				keys = append(keys, k)
+			}
+			assert.ElementsMatch(t, tc.wantKeys, keys)
+		})
+	}
+}
+
+func TestTrackerQuery_Join(t *testing.T) {
+	assert.Equal(t, "a=1&b=2", TrackerQuery{}.Join("a=1&b=2"))
+	assert.Equal(t, "auth=123&a=1&b=2", TrackerQuery{Before: "auth=123"}.Join("a=1&b=2"))
+	assert.Equal(t, "a=1&b=2&uid=9", TrackerQuery{After: "uid=9"}.Join("a=1&b=2"))
+	assert.Equal(t, "auth=123&a=1&uid=9", TrackerQuery{Before: "auth=123", After: "uid=9"}.Join("a=1"))
+}
+
+func TestPerTrackerTorrentID(t *testing.T) {
+	u, _ := url.Parse("http://example.com/tracker/announce?auth=123&info_hash=abc")
+	assert.Equal(t, "http://example.com/tracker/announce--abc", PerTrackerTorrentID(u, "abc"))
+}
+
+func TestReplaceHeaders(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://example.com/announce", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "OldAgent/1.0")
+	req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+
+	ReplaceHeaders(req, []Header{
+		{Name: "User-Agent", Value: "NewAgent/1.0"},
+		{Name: "Accept", Value: "*/*"},
+	})
+
+	assert.Len(t, req.Header, 2)
+	assert.Equal(t, "NewAgent/1.0", req.Header.Get("User-Agent"))
+	assert.Equal(t, "*/*", req.Header.Get("Accept"))
+}
+
+func TestScrapeURL(t *testing.T) {
+	infoHash := "1234567890abcdefghij" // 20 bytes
+	escapedInfoHash := url.QueryEscape(infoHash)
+
+	testCases := []struct {
+		name              string
+		announceURLStr    string
+		infoHash          string
+		trackerQuery      TrackerQuery
+		expectedScrapeURL string // Expected URL string, or empty if nil expected
+	}{
+		{
+			name:              "HTTP announce URL",
+			announceURLStr:    "http://tracker.example.com/announce",
+			infoHash:          infoHash,
+			expectedScrapeURL: "http://tracker.example.com/scrape?info_hash=" + escapedInfoHash,
+		},
+		{
+			name:              "HTTPS announce URL",
+			announceURLStr:    "https://secure.tracker.org:8080/announce",
+			infoHash:          infoHash,
+			expectedScrapeURL: "https://secure.tracker.org:8080/scrape?info_hash=" + escapedInfoHash,
+		},
+		{
+			name:              "Announce URL with existing query",
+			announceURLStr:    "http://tracker.example.com/announce?passkey=xyz",
+			infoHash:          infoHash,
+			expectedScrapeURL: "http://tracker.example.com/scrape?info_hash=" + escapedInfoHash, // Original query should be replaced
+		},
+		{
+			name:              "Announce URL not ending in /announce",
+			announceURLStr:    "http://tracker.example.com/announce_extra",
+			infoHash:          infoHash,
+			expectedScrapeURL: "", // Should return nil
+		},
+		{
+			name:              "Announce URL path only /",
+			announceURLStr:    "http://tracker.example.com/",
+			infoHash:          infoHash,
+			expectedScrapeURL: "", // Should return nil
+		},
+		{
+			name:              "Announce URL no path",
+			announceURLStr:    "http://tracker.example.com",
+			infoHash:          infoHash,
+			expectedScrapeURL: "", // Should return nil
+		},
+		{
+			name:              "With private tracker query",
+			announceURLStr:    "http://private.tracker/announce",
+			infoHash:          infoHash,
+			trackerQuery:      TrackerQuery{Before: "passkey=abc&uid=123"},
+			expectedScrapeURL: "http://private.tracker/scrape?passkey=abc&uid=123&info_hash=" + escapedInfoHash,
+		},
+		{
+			name:              "With tracker query after",
+			announceURLStr:    "http://private.tracker/announce",
+			infoHash:          infoHash,
+			trackerQuery:      TrackerQuery{Before: "passkey=abc", After: "uid=123"},
+			expectedScrapeURL: "http://private.tracker/scrape?passkey=abc&info_hash=" + escapedInfoHash + "&uid=123",
+		},
+		{
+			name:              "Passkey in path",
+			announceURLStr:    "http://private.tracker/abcdef0123/announce",
+			infoHash:          infoHash,
+			expectedScrapeURL: "http://private.tracker/abcdef0123/scrape?info_hash=" + escapedInfoHash,
+		},
+		{
+			name:              "Passkey in path and query",
+			announceURLStr:    "http://private.tracker/abcdef0123/announce",
+			infoHash:          infoHash,
+			trackerQuery:      TrackerQuery{Before: "uid=123"},
+			expectedScrapeURL: "http://private.tracker/abcdef0123/scrape?uid=123&info_hash=" + escapedInfoHash,
+		},
+		{
+			name:              "Announce URL with complex path",
+			announceURLStr:    "http://tracker.example.com/tracker/announce",
+			infoHash:          infoHash,
+			expectedScrapeURL: "http://tracker.example.com/tracker/scrape?info_hash=" + escapedInfoHash,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			announceURL, err := url.Parse(tc.announceURLStr)
+			if err != nil {
+				t.Fatalf("Failed to parse announce URL '%s': %v", tc.announceURLStr, err)
+			}
+
+			actualURL := ScrapeURL(announceURL, tc.infoHash, tc.trackerQuery)
+
+			if tc.expectedScrapeURL == "" {
+				assert.Nil(t, actualURL, "Expected nil URL")
+			} else {
+				assert.NotNil(t, actualURL, "Expected non-nil URL")
+				if actualURL != nil {
+					expectedParsedURL, _ := url.Parse(tc.expectedScrapeURL)
+					assert.Equal(t, expectedParsedURL.Scheme, actualURL.Scheme, "Scheme mismatch")
+					assert.Equal(t, expectedParsedURL.Host, actualURL.Host, "Host mismatch")
+					assert.Equal(t, expectedParsedURL.Path, actualURL.Path, "Path mismatch")
+					assert.Equal(t, expectedParsedURL.Query(), actualURL.Query(), "Query mismatch")
+					assert.Equal(t, tc.expectedScrapeURL, actualURL.String(), "Full URL string mismatch")
+				}
+			}
+		})
+	}
+}
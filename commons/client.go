@@ -0,0 +1,28 @@
+package commons
+
+import (
+	"github.com/anacrolix/torrent"
+)
+
+// ClientIdentity is how a client identifies itself outside of announces: in
+// BitTorrent handshakes, extended handshakes (BEP 10), web seed requests and
+// UPnP port mappings. It must match the announces, otherwise a tracker or peer
+// correlating them sees the anacrolix/torrent defaults.
+type ClientIdentity struct {
+	// PeerID is the session peer_id, also used in announces.
+	PeerID string
+	Bep20  string
+	// ExtendedHandshakeClientVersion is the "v" of the extended handshake.
+	ExtendedHandshakeClientVersion string
+	HTTPUserAgent                  string
+	UpnpID                         string
+}
+
+// Apply sets the identity to the torrent.ClientConfig.
+func (c *ClientIdentity) Apply(cfg *torrent.ClientConfig) {
+	cfg.PeerID = c.PeerID
+	cfg.Bep20 = c.Bep20
+	cfg.ExtendedHandshakeClientVersion = c.ExtendedHandshakeClientVersion
+	cfg.HTTPUserAgent = c.HTTPUserAgent
+	cfg.UpnpID = c.UpnpID
+}
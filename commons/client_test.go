@@ -0,0 +1,26 @@
+package commons
+
+import (
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIdentity_Apply(t *testing.T) {
+	c := &ClientIdentity{
+		PeerID:                         "-TR4060-abcdefghijkl",
+		Bep20:                          "-TR4060-",
+		ExtendedHandshakeClientVersion: "Transmission 4.0.6",
+		HTTPUserAgent:                  "Transmission/4.0.6",
+		UpnpID:                         "Transmission",
+	}
+	cfg := torrent.NewDefaultClientConfig()
+	c.Apply(cfg)
+
+	assert.Equal(t, c.PeerID, cfg.PeerID)
+	assert.Equal(t, c.Bep20, cfg.Bep20)
+	assert.Equal(t, c.ExtendedHandshakeClientVersion, cfg.ExtendedHandshakeClientVersion)
+	assert.Equal(t, c.HTTPUserAgent, cfg.HTTPUserAgent)
+	assert.Equal(t, c.UpnpID, cfg.UpnpID)
+}
@@ -35,6 +35,14 @@ func FixedDef(name, value string) *QueryDef {
 	return d
 }
 
+// CopyDef outputs the value of query "from" under name, for clients sending
+// the same value twice, e.g. BiglyBT "azudp" is the "port".
+func CopyDef(name, from string) *QueryDef {
+	d := &QueryDef{name: name, value: from}
+	d.process = d.copy
+	return d
+}
+
 func (d *QueryDef) mustHave(q url.Values) (*QueryParam, error) {
 	if !q.Has(d.name) {
 		return nil, fmt.Errorf("query %s not found", d.name)
@@ -53,6 +61,13 @@ func (d *QueryDef) fixed(q url.Values) (*QueryParam, error) {
 	return &QueryParam{Name: d.name, Value: d.value}, nil
 }
 
+func (d *QueryDef) copy(q url.Values) (*QueryParam, error) {
+	if !q.Has(d.value) {
+		return nil, fmt.Errorf("query %s not found", d.value)
+	}
+	return &QueryParam{Name: d.name, Value: q.Get(d.value)}, nil
+}
+
 type QueryParam struct {
 	Name  string
 	Value string
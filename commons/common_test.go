@@ -53,6 +53,23 @@ func TestQueryDef_Fixed(t *testing.T) {
 	assert.Equal(t, "fixedValue", param.Value, "fixedDef returned incorrect param value")
 }
 
+func TestQueryDef_Copy(t *testing.T) {
+	def := CopyDef("copy", "from")
+	q := url.Values{}
+	q.Set("from", "value3")
+
+	param, err := def.process(q)
+	require.NoError(t, err, "copyDef failed unexpectedly")
+	require.NotNil(t, param, "copyDef returned nil param unexpectedly")
+	assert.Equal(t, "copy", param.Name, "copyDef returned incorrect param name")
+	assert.Equal(t, "value3", param.Value, "copyDef returned incorrect param value")
+
+	qMissing := url.Values{}
+	paramMissing, errMissing := def.process(qMissing)
+	require.Error(t, errMissing, "copyDef did not return error when source query param was missing")
+	assert.Nil(t, paramMissing, "copyDef returned non-nil param when source query param was missing")
+}
+
 func TestProcessQuery(t *testing.T) {
 	defs := []*QueryDef{
 		MustHaveDef("req"),
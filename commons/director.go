@@ -0,0 +1,176 @@
+package commons
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+)
+
+// ClientDef describes a client whose announces differ from anacrolix/torrent
+// only in query order, fixed params, numwant, headers and the peer_id and key
+// format. NewClientDirector builds the HttpRequestDirector from it, the
+// profile packages supply only the data.
+type ClientDef struct {
+	// Name is the client name in logs.
+	Name string
+	// Client is the identity outside of announces, PeerID is set to the session
+	// peer_id.
+	Client ClientIdentity
+	// Headers replace the headers of announces.
+	Headers []Header
+	// QueryDefs are the announce params in the order the client sends them.
+	// "peer_id", "key" and "numwant" are set before processing.
+	QueryDefs []*QueryDef
+	NumWant   NumWant
+	Scopes    Scopes
+	// NewIdentity creates the peer_id and key.
+	NewIdentity func() *Identity
+	// Store persists peer_id and key across restarts, in memory only if nil.
+	Store IdentityStore
+}
+
+// NumWant is the numwant a client sends, used only if QueryDefs has
+// MustHaveDef("numwant").
+type NumWant struct {
+	Announce int
+	// Stopped is sent with stopped announces, 0 for clients not wanting peers
+	// when stopping.
+	Stopped int
+}
+
+// ClientDirector rewrites announces as described by a ClientDef.
+type ClientDirector struct {
+	def    *ClientDef
+	client ClientIdentity
+	logger log.Logger
+	// announce url + info_hash -> peer_id, key
+	torrents *Identities
+	// stops idle expiry.
+	cancel context.CancelFunc
+}
+
+// NewClientDirector creates a ClientDirector announcing as def. It fails if the
+// identities can not be restored from def.Store.
+func NewClientDirector(def *ClientDef) (*ClientDirector, error) {
+	torrents := NewIdentities(def.Scopes, def.NewIdentity)
+	if def.Store != nil {
+		var err error
+		if torrents, err = NewStoredIdentities(def.Scopes, def.NewIdentity, def.Client.Bep20, def.Store); err != nil {
+			return nil, err
+		}
+	}
+	client := def.Client
+	client.PeerID = torrents.Session().PeerID
+	s := &ClientDirector{
+		def:      def,
+		client:   client,
+		logger:   log.NewLogger(def.Name),
+		torrents: torrents,
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go ExpireEvery(ctx, IdleCheckInterval, s.expireIdle)
+	return s, nil
+}
+
+// ConfigureClient applies the client identity, so BitTorrent handshakes use the
+// same peer_id as announces with session peer_id scope.
+func (s *ClientDirector) ConfigureClient(cfg *torrent.ClientConfig) {
+	s.client.Apply(cfg)
+}
+
+// Close stops idle expiry and persists the identities to ClientDef.Store.
+func (s *ClientDirector) Close(ctx context.Context) error {
+	s.cancel()
+	return s.torrents.Close()
+}
+
+// SetIdleTTL changes how long a torrent on a tracker is kept without announces,
+// DefaultIdleTTL by default. 0 keeps it until stopped.
+func (s *ClientDirector) SetIdleTTL(ttl time.Duration) {
+	s.torrents.SetIdleTTL(ttl)
+}
+
+// Evicted returns the number of torrents on trackers removed for idle, which
+// were dropped by anacrolix/torrent without announcing stopped.
+func (s *ClientDirector) Evicted() int64 {
+	return s.torrents.Evicted()
+}
+
+// expireIdle removes idle peer_id and key.
+func (s *ClientDirector) expireIdle() {
+	if expired := s.torrents.Expire(); len(expired) > 0 {
+		s.logger.Levelf(log.Info, "Expired %d idle torrents", len(expired))
+	}
+}
+
+func (s *ClientDirector) ChangeHttpRequest(r *http.Request) error {
+	// Do nothing for scrape request. anacrolix/torrent does not call HttpRequestDirector right now.
+	// Just incase the behavior changed.
+	if IsScrape(r.URL) {
+		return nil
+	}
+
+	err := s.modifyQuery(r)
+	if err != nil {
+		return err
+	}
+	ReplaceHeaders(r, s.def.Headers)
+	return nil
+}
+
+func (s *ClientDirector) modifyQuery(r *http.Request) error {
+	// RawQuery may contains private tracker's own query, like passkey.
+	trackerQuery, q := SplitAnnounceQuery(r.URL.RawQuery)
+
+	// anacrolix/torrent does not provide "numwant", and assign fixed value for
+	// "compact", "supportcrypto". Ensure this behavior does not change.
+	if q.Has("numwant") {
+		return fmt.Errorf("anacrolix/torrent provides numwant")
+	}
+	if q.Get("compact") != "1" {
+		return fmt.Errorf("anacrolix/torrent provides compact!=1")
+	}
+	if q.Get("supportcrypto") != "1" {
+		return fmt.Errorf("anacrolix/torrent provides supportcrypto!=1")
+	}
+
+	infoHash := q.Get("info_hash")
+	if infoHash == "" {
+		return fmt.Errorf("missing info_hash")
+	}
+	event := q.Get("event")
+
+	id := PerTrackerTorrentID(r.URL, infoHash)
+	pt, exists := s.torrents.LoadOrCreate(id, infoHash)
+	if event == EventStarted {
+		// It is a bug if exists.
+		if exists {
+			s.logger.Levelf(log.Error, "start a torrent already started")
+		}
+	} else if event == EventStopped {
+		s.torrents.Delete(id)
+	}
+
+	q.Set("peer_id", pt.PeerID)
+	q.Set("key", pt.Key)
+	if event == EventStopped {
+		q.Set("numwant", strconv.Itoa(s.def.NumWant.Stopped))
+	} else {
+		q.Set("numwant", strconv.Itoa(s.def.NumWant.Announce))
+	}
+
+	params, err := ProcessQuery(s.def.QueryDefs, q)
+	if err != nil {
+		return err
+	}
+
+	r.URL.RawQuery = trackerQuery.Join(params.Str())
+
+	return nil
+}
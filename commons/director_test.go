@@ -0,0 +1,228 @@
+package commons
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAnnounceQuery = "?compact=1&downloaded=0&event=started&info_hash=%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0"
+
+func newTestClientDef(scopes Scopes) *ClientDef {
+	return &ClientDef{
+		Name: "test",
+		Client: ClientIdentity{
+			Bep20:                          "-TE0100-",
+			ExtendedHandshakeClientVersion: "Test 1.0",
+			HTTPUserAgent:                  "Test/1.0",
+			UpnpID:                         "Test",
+		},
+		Headers: []Header{{Name: "User-Agent", Value: "Test/1.0"}},
+		QueryDefs: []*QueryDef{
+			MustHaveDef("info_hash"),
+			MustHaveDef("peer_id"),
+			MustHaveDef("key"),
+			OptionalDef("event"),
+			MustHaveDef("numwant"),
+			FixedDef("no_peer_id", "1"),
+		},
+		NumWant: NumWant{Announce: 50, Stopped: 0},
+		Scopes:  scopes,
+		NewIdentity: func() *Identity {
+			return &Identity{PeerID: "-TE0100-" + RandomString(AlphaNumLower, 12), Key: RandomString(AlphaNumLower, 8)}
+		},
+	}
+}
+
+func newTestClientDirector(t *testing.T, def *ClientDef) *ClientDirector {
+	rd, err := NewClientDirector(def)
+	require.NoError(t, err)
+	return rd
+}
+
+func TestClientDirector_Scrape(t *testing.T) {
+	rd := newTestClientDirector(t, newTestClientDef(Scopes{}))
+	req, err := http.NewRequest("GET", "http://example.com/tracker/scrape?info_hash=123&unrelated_args=456", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "Teapot/1.0")
+
+	originalURL := req.URL.String()
+	originalHeader := req.Header.Clone()
+
+	require.NoError(t, rd.ChangeHttpRequest(req))
+	assert.Equal(t, originalURL, req.URL.String(), "URL should not be modified for scrape requests")
+	assert.Equal(t, originalHeader, req.Header, "Headers should not be modified for scrape requests")
+}
+
+func TestClientDirector_Announce(t *testing.T) {
+	rd := newTestClientDirector(t, newTestClientDef(Scopes{}))
+	req, err := http.NewRequest("GET", "http://example.com/tracker/announce?auth=123&"+testAnnounceQuery[1:], nil)
+	require.NoError(t, err)
+	req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+	require.NoError(t, rd.ChangeHttpRequest(req))
+
+	assert.Equal(t, http.Header{"User-Agent": {"Test/1.0"}}, req.Header)
+	q, err := QueryParamsFromRawQueryStr(req.URL.RawQuery)
+	require.NoError(t, err)
+	var names []string
+	for _, p := range q {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"auth", "info_hash", "peer_id", "key", "event", "numwant", "no_peer_id"}, names)
+	assert.True(t, strings.HasPrefix(q[2].Value, "-TE0100-"))
+	assert.NotEqual(t, "1234", q[3].Value)
+	assert.Equal(t, "50", q[5].Value)
+}
+
+func TestClientDirector_AnacrolixQuery(t *testing.T) {
+	testCases := []struct {
+		name     string
+		rawQuery string
+		wantErr  string
+	}{
+		{
+			name:     "numwant",
+			rawQuery: testAnnounceQuery + "&numwant=10",
+			wantErr:  "numwant",
+		},
+		{
+			name:     "compact",
+			rawQuery: strings.Replace(testAnnounceQuery, "compact=1", "compact=0", 1),
+			wantErr:  "compact!=1",
+		},
+		{
+			name:     "supportcrypto",
+			rawQuery: strings.Replace(testAnnounceQuery, "&supportcrypto=1", "", 1),
+			wantErr:  "supportcrypto!=1",
+		},
+		{
+			name:     "info_hash",
+			rawQuery: strings.Replace(testAnnounceQuery, "info_hash=", "info_hash_=", 1),
+			wantErr:  "missing info_hash",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rd := newTestClientDirector(t, newTestClientDef(Scopes{}))
+			req, err := http.NewRequest("GET", "http://example.com/tracker/announce"+tc.rawQuery, nil)
+			require.NoError(t, err)
+			assert.ErrorContains(t, rd.ChangeHttpRequest(req), tc.wantErr)
+		})
+	}
+}
+
+func TestClientDirector_PerTorrentHandling(t *testing.T) {
+	rd := newTestClientDirector(t, newTestClientDef(Scopes{}))
+	infoHashUnescaped, _ := url.QueryUnescape("%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9")
+	announce := "http://example.com/tracker/announce"
+	id := announce + "--" + infoHashUnescaped
+
+	announceOnce := func(rawQuery string) url.Values {
+		req, err := http.NewRequest("GET", announce+rawQuery, nil)
+		require.NoError(t, err)
+		require.NoError(t, rd.ChangeHttpRequest(req))
+		return req.URL.Query()
+	}
+
+	q1 := announceOnce(testAnnounceQuery)
+	pt, ok := rd.torrents.Load(id)
+	require.True(t, ok)
+	assert.Equal(t, pt.PeerID, q1.Get("peer_id"))
+	assert.Equal(t, pt.Key, q1.Get("key"))
+
+	q2 := announceOnce(strings.Replace(testAnnounceQuery, "event=started&", "", 1))
+	assert.Equal(t, q1.Get("peer_id"), q2.Get("peer_id"), "peer_id should be reused")
+	assert.Equal(t, q1.Get("key"), q2.Get("key"), "key should be reused")
+	assert.Equal(t, "50", q2.Get("numwant"))
+
+	q3 := announceOnce(strings.Replace(testAnnounceQuery, "event=started", "event=stopped", 1))
+	assert.Equal(t, "0", q3.Get("numwant"), "numwant when stopped")
+	_, ok = rd.torrents.Load(id)
+	assert.False(t, ok, "identity should be removed after stopped")
+}
+
+func TestClientDirector_ConfigureClient(t *testing.T) {
+	testCases := []struct {
+		name   string
+		scopes Scopes
+	}{
+		{name: "session", scopes: Scopes{PeerID: ScopeSession, Key: ScopeSession}},
+		{name: "torrent", scopes: Scopes{PeerID: ScopeTorrent, Key: ScopeTracker}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			def := newTestClientDef(tc.scopes)
+			rd := newTestClientDirector(t, def)
+			cfg := torrent.NewDefaultClientConfig()
+			rd.ConfigureClient(cfg)
+
+			assert.True(t, strings.HasPrefix(cfg.PeerID, "-TE0100-"))
+			assert.Equal(t, "-TE0100-", cfg.Bep20)
+			assert.Equal(t, "Test 1.0", cfg.ExtendedHandshakeClientVersion)
+			assert.Equal(t, "Test/1.0", cfg.HTTPUserAgent)
+			assert.Equal(t, "Test", cfg.UpnpID)
+			assert.Empty(t, def.Client.PeerID, "def is not changed")
+
+			req, err := http.NewRequest("GET", "http://example.com/announce"+testAnnounceQuery, nil)
+			require.NoError(t, err)
+			require.NoError(t, rd.ChangeHttpRequest(req))
+			peerID := req.URL.Query().Get("peer_id")
+			if tc.scopes.PeerID == ScopeSession {
+				assert.Equal(t, cfg.PeerID, peerID, "handshakes use the announce peer_id")
+			} else {
+				assert.NotEqual(t, cfg.PeerID, peerID, "peer_id is per torrent")
+			}
+		})
+	}
+}
+
+func TestClientDirector_Store(t *testing.T) {
+	store := NewMemoryStore()
+	announce := func(rd *ClientDirector) url.Values {
+		req, err := http.NewRequest("GET", "http://example.com/announce"+testAnnounceQuery, nil)
+		require.NoError(t, err)
+		require.NoError(t, rd.ChangeHttpRequest(req))
+		return req.URL.Query()
+	}
+	def := newTestClientDef(Scopes{})
+	def.Store = store
+	q1 := announce(newTestClientDirector(t, def))
+
+	// Restart without stopped.
+	q2 := announce(newTestClientDirector(t, def))
+	assert.Equal(t, q1.Get("peer_id"), q2.Get("peer_id"))
+	assert.Equal(t, q1.Get("key"), q2.Get("key"))
+
+	// Stored by another client.
+	def.Client.Bep20 = "-TE0200-"
+	_, err := NewClientDirector(def)
+	require.NoError(t, err)
+	_, trackers, err := store.Load()
+	require.NoError(t, err)
+	assert.Empty(t, trackers, "identities of another client are dropped")
+}
+
+func TestClientDirector_ExpireIdle(t *testing.T) {
+	rd := newTestClientDirector(t, newTestClientDef(Scopes{}))
+	defer rd.Close(context.Background())
+	rd.SetIdleTTL(time.Millisecond)
+	req, err := http.NewRequest("GET", "http://example.com/announce"+testAnnounceQuery, nil)
+	require.NoError(t, err)
+	require.NoError(t, rd.ChangeHttpRequest(req))
+
+	time.Sleep(5 * time.Millisecond)
+	rd.expireIdle()
+	infoHash, _ := url.QueryUnescape("%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9")
+	_, ok := rd.torrents.Load(PerTrackerTorrentID(req.URL, infoHash))
+	assert.False(t, ok, "idle identity is removed")
+	assert.EqualValues(t, 1, rd.Evicted())
+}
@@ -0,0 +1,377 @@
+package commons
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+var (
+	logger = log.NewLogger("commons")
+)
+
+const (
+	// AlphaNumLower is the charset of Transmission peer_id.
+	AlphaNumLower = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+	// DefaultIdleTTL is how long an Identity is kept without announces. Trackers
+	// ask for announces every 30 min to 2 hours, a torrent not announced for
+	// longer was dropped without sending stopped.
+	DefaultIdleTTL = 6 * time.Hour
+
+	// IdleCheckInterval is how often idle Identities should be expired.
+	IdleCheckInterval = 10 * time.Minute
+)
+
+// Identity is the peer_id and key a client reports to trackers.
+type Identity struct {
+	PeerID string
+	Key    string
+}
+
+// Scope is how widely a client shares a peer_id or key.
+type Scope int
+
+const (
+	// ScopeTracker is one value per torrent on each tracker, the default.
+	ScopeTracker Scope = iota
+	// ScopeTorrent is one value per torrent, shared by all its trackers.
+	ScopeTorrent
+	// ScopeSession is one value shared by all torrents.
+	ScopeSession
+)
+
+var scopeNames = []string{"tracker", "torrent", "session"}
+
+func (s Scope) String() string {
+	if int(s) < len(scopeNames) {
+		return scopeNames[s]
+	}
+	return fmt.Sprintf("Scope(%d)", int(s))
+}
+
+// ParseScope parses "tracker", "torrent" or "session".
+func ParseScope(s string) (Scope, error) {
+	for i, name := range scopeNames {
+		if s == name {
+			return Scope(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown scope %q", s)
+}
+
+// Scopes selects the Scope of peer_id and key.
+type Scopes struct {
+	PeerID Scope
+	Key    Scope
+}
+
+// Identities stores the Identity of each torrent on each tracker, keyed by
+// PerTrackerTorrentID. peer_id and key are shared across trackers and torrents
+// as Scopes says.
+type Identities struct {
+	mu     sync.Mutex
+	scopes Scopes
+	create func() *Identity
+	now    func() time.Time
+	// 0 never expires.
+	idleTTL time.Duration
+	evicted int64
+	// nil if not persisted, never changes.
+	store IdentityStore
+	// session values, also used as the client peer_id.
+	session *Identity
+	// info_hash -> torrent values.
+	torrents map[string]*torrentIdentity
+	// PerTrackerTorrentID -> Identity
+	trackers map[string]*trackerIdentity
+}
+
+type torrentIdentity struct {
+	identity *Identity
+	// number of trackers announced the torrent and not stopped.
+	refs int
+}
+
+type trackerIdentity struct {
+	identity *Identity
+	infoHash string
+	// restored from IdentityStore and not loaded yet.
+	restored bool
+	lastSeen time.Time
+}
+
+// NewIdentities creates Identities which use create to make new Identity.
+// Identities not loaded for DefaultIdleTTL are expired by Expire.
+func NewIdentities(scopes Scopes, create func() *Identity) *Identities {
+	return &Identities{
+		scopes:   scopes,
+		create:   create,
+		now:      time.Now,
+		idleTTL:  DefaultIdleTTL,
+		session:  create(),
+		torrents: map[string]*torrentIdentity{},
+		trackers: map[string]*trackerIdentity{},
+	}
+}
+
+// NewStoredIdentities creates Identities persisted in store, restoring the
+// identities stored before. Stored identities whose peer_id does not start with
+// bep20 were created by another client or version, they are dropped from store.
+func NewStoredIdentities(scopes Scopes, create func() *Identity, bep20 string, store IdentityStore) (*Identities, error) {
+	session, trackers, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewIdentities(scopes, create)
+	s.store = store
+	if session != nil && strings.HasPrefix(session.PeerID, bep20) {
+		s.session = session
+	} else if err := store.SetSession(*s.session); err != nil {
+		return nil, err
+	}
+
+	dropped := 0
+	for id, stored := range trackers {
+		if !strings.HasPrefix(stored.PeerID, bep20) {
+			if err := store.Delete(id); err != nil {
+				return nil, err
+			}
+			dropped++
+			continue
+		}
+		// All trackers of a torrent share the torrent scope values.
+		t, ok := s.torrents[stored.InfoHash]
+		if !ok {
+			t = &torrentIdentity{identity: &Identity{PeerID: stored.PeerID, Key: stored.Key}}
+			s.torrents[stored.InfoHash] = t
+		}
+		t.refs++
+		s.trackers[id] = &trackerIdentity{
+			identity: &Identity{PeerID: stored.PeerID, Key: stored.Key},
+			infoHash: stored.InfoHash,
+			restored: true,
+			lastSeen: s.now(),
+		}
+	}
+	if dropped > 0 {
+		logger.Levelf(log.Info, "Dropped %d stored identities not of %s", dropped, bep20)
+	}
+	return s, nil
+}
+
+// Session returns the session Identity. Its peer_id should be used outside of
+// announces, see ClientIdentity.
+func (s *Identities) Session() Identity {
+	return *s.session
+}
+
+// LoadOrCreate returns the Identity stored for id, the torrent infoHash on a
+// tracker, creating a new one if not exists. The bool reports whether the
+// Identity already existed. Identity restored from IdentityStore is reported
+// as not existed on first load, the torrent is new to this process.
+func (s *Identities) LoadOrCreate(id, infoHash string) (*Identity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if got, ok := s.trackers[id]; ok {
+		restored := got.restored
+		got.restored = false
+		got.lastSeen = s.now()
+		return got.identity, !restored
+	}
+
+	fresh := s.create()
+	t, ok := s.torrents[infoHash]
+	if !ok {
+		t = &torrentIdentity{identity: fresh}
+		s.torrents[infoHash] = t
+	}
+	t.refs++
+
+	pick := func(scope Scope, session, torrent, tracker string) string {
+		switch scope {
+		case ScopeSession:
+			return session
+		case ScopeTorrent:
+			return torrent
+		}
+		return tracker
+	}
+	pt := &Identity{
+		PeerID: pick(s.scopes.PeerID, s.session.PeerID, t.identity.PeerID, fresh.PeerID),
+		Key:    pick(s.scopes.Key, s.session.Key, t.identity.Key, fresh.Key),
+	}
+	s.trackers[id] = &trackerIdentity{identity: pt, infoHash: infoHash, lastSeen: s.now()}
+	if s.store != nil {
+		err := s.store.Put(id, StoredIdentity{InfoHash: infoHash, PeerID: pt.PeerID, Key: pt.Key})
+		if err != nil {
+			logger.Levelf(log.Error, "Failed to store identity: %v", err)
+		}
+	}
+	return pt, false
+}
+
+// Load returns the Identity stored for id.
+func (s *Identities) Load(id string) (*Identity, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	got, ok := s.trackers[id]
+	if !ok {
+		return nil, false
+	}
+	return got.identity, true
+}
+
+// Delete removes the Identity stored for id. Torrent values are removed after
+// all trackers of the torrent are deleted.
+func (s *Identities) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delete(id)
+}
+
+func (s *Identities) delete(id string) {
+	got, ok := s.trackers[id]
+	if !ok {
+		return
+	}
+	delete(s.trackers, id)
+	if s.store != nil {
+		if err := s.store.Delete(id); err != nil {
+			logger.Levelf(log.Error, "Failed to delete stored identity: %v", err)
+		}
+	}
+
+	t := s.torrents[got.infoHash]
+	t.refs--
+	if t.refs == 0 {
+		delete(s.torrents, got.infoHash)
+	}
+}
+
+// Close persists the Identities to the store, if any.
+func (s *Identities) Close() error {
+	// store is set on creation, Close does not hold mu while writing.
+	if s.store == nil {
+		return nil
+	}
+	return s.store.Close()
+}
+
+// SetClock replaces time.Now, for tests.
+func (s *Identities) SetClock(now func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.now = now
+}
+
+// SetIdleTTL changes how long an Identity is kept without LoadOrCreate, 0
+// keeps it until Delete.
+func (s *Identities) SetIdleTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.idleTTL = ttl
+}
+
+// Expire removes the Identities not loaded by LoadOrCreate for the idle TTL,
+// and returns their ids. anacrolix/torrent may drop a torrent or a tracker
+// without announcing stopped, callers should call it every IdleCheckInterval
+// and clean up their own per id states.
+func (s *Identities) Expire() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.idleTTL == 0 {
+		return nil
+	}
+	var expired []string
+	deadline := s.now().Add(-s.idleTTL)
+	for id, t := range s.trackers {
+		if t.lastSeen.Before(deadline) {
+			expired = append(expired, id)
+		}
+	}
+	for _, id := range expired {
+		s.delete(id)
+	}
+	s.evicted += int64(len(expired))
+	return expired
+}
+
+// ExpireEvery calls expire every interval until ctx is done. Profiles run it in
+// a goroutine, whether they scrape or not.
+func ExpireEvery(ctx context.Context, interval time.Duration, expire func()) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			expire()
+		}
+	}
+}
+
+// Evicted returns the number of Identities removed by Expire.
+func (s *Identities) Evicted() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.evicted
+}
+
+// RandomString returns n random chars from charSet.
+func RandomString(charSet string, n int) string {
+	b := make([]byte, n)
+	for i := range b {
+		m, err := rand.Int(rand.Reader, big.NewInt(int64(len(charSet))))
+		if err != nil {
+			// crypto/rand should not fail on Linux/macOS. Panic if it does.
+			panic(fmt.Errorf("failed to generate random int: %w", err))
+		}
+		b[i] = charSet[m.Int64()]
+	}
+	return string(b)
+}
+
+// RandomBytes returns n random bytes.
+func RandomBytes(n int) []byte {
+	return RandomBytesFrom(rand.Reader, n)
+}
+
+// RandomBytesFrom returns n bytes read from r, a random source replacing
+// crypto/rand in tests.
+func RandomBytesFrom(r io.Reader, n int) []byte {
+	b := make([]byte, n)
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		// crypto/rand should not fail on Linux/macOS. Panic if it does.
+		panic(fmt.Errorf("failed to generate random bytes: %w", err))
+	}
+	return b
+}
+
+// RandomUint32 returns a random uint32.
+func RandomUint32() uint32 {
+	return RandomUint32From(rand.Reader)
+}
+
+// RandomUint32From returns a uint32 read from r.
+func RandomUint32From(r io.Reader) uint32 {
+	return binary.BigEndian.Uint32(RandomBytesFrom(r, 4))
+}
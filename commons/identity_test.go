@@ -0,0 +1,206 @@
+package commons
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentities(t *testing.T) {
+	created := 0
+	s := NewIdentities(Scopes{}, func() *Identity {
+		created++
+		return &Identity{PeerID: strings.Repeat("a", created), Key: "key"}
+	})
+	assert.Equal(t, "a", s.Session().PeerID)
+
+	_, ok := s.Load("id")
+	assert.False(t, ok)
+
+	first, exists := s.LoadOrCreate("id", "hash")
+	assert.False(t, exists)
+	assert.Equal(t, "aa", first.PeerID)
+
+	second, exists := s.LoadOrCreate("id", "hash")
+	assert.True(t, exists)
+	assert.Same(t, first, second)
+
+	got, ok := s.Load("id")
+	require.True(t, ok)
+	assert.Same(t, first, got)
+
+	s.Delete("id")
+	_, ok = s.Load("id")
+	assert.False(t, ok)
+
+	third, exists := s.LoadOrCreate("id", "hash")
+	assert.False(t, exists)
+	assert.Equal(t, "aaa", third.PeerID)
+}
+
+func TestIdentities_Scopes(t *testing.T) {
+	created := 0
+	create := func() *Identity {
+		created++
+		return &Identity{PeerID: fmt.Sprintf("peer%d", created), Key: fmt.Sprintf("key%d", created)}
+	}
+
+	testCases := []struct {
+		name   string
+		scopes Scopes
+		// expected values of: hash1 on tracker1, hash1 on tracker2, hash2 on
+		// tracker1, hash1 on tracker1 after all trackers of hash1 stopped.
+		peerIDs []string
+		keys    []string
+	}{
+		{
+			name:    "tracker",
+			scopes:  Scopes{PeerID: ScopeTracker, Key: ScopeTracker},
+			peerIDs: []string{"peer2", "peer3", "peer4", "peer6"},
+			keys:    []string{"key2", "key3", "key4", "key6"},
+		},
+		{
+			name:    "torrent",
+			scopes:  Scopes{PeerID: ScopeTorrent, Key: ScopeTorrent},
+			peerIDs: []string{"peer2", "peer2", "peer4", "peer6"},
+			keys:    []string{"key2", "key2", "key4", "key6"},
+		},
+		{
+			name:    "session",
+			scopes:  Scopes{PeerID: ScopeSession, Key: ScopeSession},
+			peerIDs: []string{"peer1", "peer1", "peer1", "peer1"},
+			keys:    []string{"key1", "key1", "key1", "key1"},
+		},
+		{
+			name:    "session peer_id, tracker key",
+			scopes:  Scopes{PeerID: ScopeSession, Key: ScopeTracker},
+			peerIDs: []string{"peer1", "peer1", "peer1", "peer1"},
+			keys:    []string{"key2", "key3", "key4", "key6"},
+		},
+		{
+			name:    "torrent peer_id, tracker key",
+			scopes:  Scopes{PeerID: ScopeTorrent, Key: ScopeTracker},
+			peerIDs: []string{"peer2", "peer2", "peer4", "peer6"},
+			keys:    []string{"key2", "key3", "key4", "key6"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			created = 0
+			s := NewIdentities(tc.scopes, create)
+
+			got := []*Identity{}
+			pt, _ := s.LoadOrCreate("tracker1--hash1", "hash1")
+			got = append(got, pt)
+			pt, _ = s.LoadOrCreate("tracker2--hash1", "hash1")
+			got = append(got, pt)
+			pt, _ = s.LoadOrCreate("tracker1--hash2", "hash2")
+			got = append(got, pt)
+
+			// Torrent values are kept until all trackers stopped.
+			s.Delete("tracker1--hash1")
+			pt, _ = s.LoadOrCreate("tracker1--hash1", "hash1")
+			if tc.scopes.PeerID != ScopeTracker {
+				assert.Equal(t, got[1].PeerID, pt.PeerID)
+			}
+			s.Delete("tracker1--hash1")
+			s.Delete("tracker2--hash1")
+
+			pt, _ = s.LoadOrCreate("tracker1--hash1", "hash1")
+			got = append(got, pt)
+
+			for i := range got {
+				assert.Equal(t, tc.peerIDs[i], got[i].PeerID, "peer_id %d", i)
+				assert.Equal(t, tc.keys[i], got[i].Key, "key %d", i)
+			}
+		})
+	}
+}
+
+func TestIdentities_Expire(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := NewIdentities(Scopes{PeerID: ScopeTorrent}, func() *Identity {
+		return &Identity{PeerID: RandomString(AlphaNumLower, 20), Key: RandomString(AlphaNumLower, 8)}
+	})
+	s.now = func() time.Time { return now }
+	s.SetIdleTTL(time.Hour)
+
+	first, _ := s.LoadOrCreate("tracker1--hash", "hash")
+	s.LoadOrCreate("tracker2--hash", "hash")
+
+	now = now.Add(40 * time.Minute)
+	s.LoadOrCreate("tracker1--hash", "hash")
+	assert.Empty(t, s.Expire())
+
+	now = now.Add(40 * time.Minute)
+	assert.Equal(t, []string{"tracker2--hash"}, s.Expire())
+	assert.EqualValues(t, 1, s.Evicted())
+	_, ok := s.Load("tracker2--hash")
+	assert.False(t, ok)
+
+	// Torrent values are kept while a tracker still announces.
+	got, exists := s.LoadOrCreate("tracker3--hash", "hash")
+	assert.False(t, exists)
+	assert.Equal(t, first.PeerID, got.PeerID)
+
+	s.SetIdleTTL(0)
+	now = now.Add(24 * time.Hour)
+	assert.Empty(t, s.Expire(), "0 never expires")
+
+	s.SetIdleTTL(time.Hour)
+	assert.ElementsMatch(t, []string{"tracker1--hash", "tracker3--hash"}, s.Expire())
+	assert.EqualValues(t, 3, s.Evicted())
+	got, _ = s.LoadOrCreate("tracker1--hash", "hash")
+	assert.NotEqual(t, first.PeerID, got.PeerID)
+}
+
+func TestExpireEvery(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	var calls atomic.Int32
+	done := make(chan struct{})
+	go func() {
+		ExpireEvery(ctx, time.Millisecond, func() { calls.Add(1) })
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return calls.Load() >= 2 }, 2*time.Second, time.Millisecond)
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("ExpireEvery did not return after ctx is done")
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	for _, scope := range []Scope{ScopeTracker, ScopeTorrent, ScopeSession} {
+		got, err := ParseScope(scope.String())
+		require.NoError(t, err)
+		assert.Equal(t, scope, got)
+	}
+	_, err := ParseScope("client")
+	assert.Error(t, err)
+}
+
+func TestRandomString(t *testing.T) {
+	s := RandomString(AlphaNumLower, 12)
+	assert.Len(t, s, 12)
+	for _, char := range s {
+		assert.True(t, strings.ContainsRune(AlphaNumLower, char), "invalid char '%c'", char)
+	}
+	assert.NotEqual(t, s, RandomString(AlphaNumLower, 12))
+}
+
+func TestRandomUint32From(t *testing.T) {
+	r := bytes.NewReader([]byte{0x12, 0x34, 0x56, 0x78, 0x9a})
+	assert.Equal(t, uint32(0x12345678), RandomUint32From(r))
+	assert.Panics(t, func() { RandomUint32From(r) }, "short read")
+}
@@ -0,0 +1,157 @@
+package commons
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// LatestVersion looks up the newest registered version of a client.
+	LatestVersion = "latest"
+
+	// Tracker protocols a client announces with.
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	ProtocolUDP   = "udp"
+)
+
+// Director rewrites announce requests, the same as
+// camouflagetorrentclients.HttpRequestDirector.
+type Director interface {
+	ChangeHttpRequest(*http.Request) error
+}
+
+// ProfileInfo describes the client a registered profile mimicks.
+type ProfileInfo struct {
+	// Client is the lower case client name, e.g. "transmission".
+	Client string
+	// Version is the client version, e.g. "4.0.6".
+	Version string
+	// Engine is the BitTorrent library of the client, e.g. "libtorrent-rasterbar 2.0".
+	Engine string
+	// Released is the release month of the version, "YYYY-MM", empty if unknown.
+	Released string
+	// Protocols are the tracker protocols the client announces with.
+	Protocols []string
+}
+
+// Name returns "client/version", the name to look up the profile.
+func (p ProfileInfo) Name() string {
+	return p.Client + "/" + p.Version
+}
+
+type registeredProfile struct {
+	info ProfileInfo
+	new  func() (Director, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	// client -> version -> profile
+	registry = map[string]map[string]*registeredProfile{}
+)
+
+// RegisterProfile registers a profile, usually in init() of the profile
+// package. It panics if the profile is registered twice.
+func RegisterProfile(info ProfileInfo, new func() (Director, error)) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	info.Client = strings.ToLower(info.Client)
+	versions, ok := registry[info.Client]
+	if !ok {
+		versions = map[string]*registeredProfile{}
+		registry[info.Client] = versions
+	}
+	if _, ok := versions[info.Version]; ok {
+		panic(fmt.Sprintf("profile %s registered twice", info.Name()))
+	}
+	versions[info.Version] = &registeredProfile{info: info, new: new}
+}
+
+// LookupProfile creates the profile of name "client/version", version can be
+// LatestVersion or omitted for the newest version. Client is case insensitive.
+func LookupProfile(name string) (Director, ProfileInfo, error) {
+	client, version, _ := strings.Cut(name, "/")
+	client = strings.ToLower(client)
+
+	registryMu.RLock()
+	p, err := lookupProfile(client, version)
+	registryMu.RUnlock()
+	if err != nil {
+		return nil, ProfileInfo{}, err
+	}
+
+	d, err := p.new()
+	if err != nil {
+		return nil, ProfileInfo{}, err
+	}
+	return d, p.info, nil
+}
+
+func lookupProfile(client, version string) (*registeredProfile, error) {
+	versions, ok := registry[client]
+	if !ok {
+		return nil, fmt.Errorf("unknown client %s", client)
+	}
+	if version == "" || version == LatestVersion {
+		var latest *registeredProfile
+		for _, p := range versions {
+			if latest == nil || CompareVersions(p.info.Version, latest.info.Version) > 0 {
+				latest = p
+			}
+		}
+		return latest, nil
+	}
+	p, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported %s version %s", client, version)
+	}
+	return p, nil
+}
+
+// Profiles returns all registered profiles, sorted by client and version.
+func Profiles() []ProfileInfo {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var res []ProfileInfo
+	for _, versions := range registry {
+		for _, p := range versions {
+			res = append(res, p.info)
+		}
+	}
+	slices.SortFunc(res, func(a, b ProfileInfo) int {
+		if c := strings.Compare(a.Client, b.Client); c != 0 {
+			return c
+		}
+		return CompareVersions(a.Version, b.Version)
+	})
+	return res
+}
+
+// CompareVersions compares dot separated versions part by part, numerically if
+// both parts are numbers, e.g. "4.0.10" > "4.0.6" and "3.00" < "4.0.5".
+func CompareVersions(a, b string) int {
+	as := strings.Split(a, ".")
+	bs := strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.Atoi(as[i])
+		bn, bErr := strconv.Atoi(bs[i])
+		var c int
+		if aErr == nil && bErr == nil {
+			c = cmp.Compare(an, bn)
+		} else {
+			c = strings.Compare(as[i], bs[i])
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return cmp.Compare(len(as), len(bs))
+}
@@ -0,0 +1,75 @@
+package commons
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type versionDirector string
+
+func (d versionDirector) ChangeHttpRequest(*http.Request) error {
+	return nil
+}
+
+func TestRegisterProfile(t *testing.T) {
+	for _, v := range []string{"1.9.0", "1.10.0", "1.2"} {
+		RegisterProfile(ProfileInfo{Client: "Test-Client", Version: v}, func() (Director, error) {
+			return versionDirector(v), nil
+		})
+	}
+
+	testCases := []struct {
+		name    string
+		want    string
+		wantErr string
+	}{
+		{name: "test-client/1.9.0", want: "1.9.0"},
+		{name: "TEST-CLIENT/1.2", want: "1.2"},
+		{name: "test-client/latest", want: "1.10.0"},
+		{name: "test-client", want: "1.10.0"},
+		{name: "test-client/2.0", wantErr: "unsupported test-client version 2.0"},
+		{name: "other-client/1.0", wantErr: "unknown client other-client"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, info, err := LookupProfile(tc.name)
+			if tc.wantErr != "" {
+				assert.EqualError(t, err, tc.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, versionDirector(tc.want), d)
+			assert.Equal(t, "test-client/"+tc.want, info.Name())
+		})
+	}
+
+	var names []string
+	for _, p := range Profiles() {
+		names = append(names, p.Name())
+	}
+	assert.Equal(t, []string{"test-client/1.2", "test-client/1.9.0", "test-client/1.10.0"}, names)
+
+	assert.Panics(t, func() {
+		RegisterProfile(ProfileInfo{Client: "test-client", Version: "1.2"}, nil)
+	})
+}
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		want int
+	}{
+		{a: "4.0.6", b: "4.0.6", want: 0},
+		{a: "4.0.10", b: "4.0.6", want: 1},
+		{a: "3.00", b: "4.0.5", want: -1},
+		{a: "3.5", b: "3.5.0.0", want: -1},
+		{a: "1.0.beta", b: "1.0.alpha", want: 1},
+	}
+	for _, tc := range testCases {
+		assert.Equal(t, tc.want, CompareVersions(tc.a, tc.b), "%s vs %s", tc.a, tc.b)
+	}
+}
@@ -0,0 +1,53 @@
+package commons
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/anacrolix/torrent/bencode"
+)
+
+// AnnounceResponse is the tracker's bencoded announce response, the fields
+// real clients react to. Peers are left to anacrolix/torrent.
+type AnnounceResponse struct {
+	// Interval is the seconds to wait before the next regular announce.
+	Interval int64 `bencode:"interval"`
+	// MinInterval is the seconds the client must wait before announcing again,
+	// 0 if the tracker does not set it.
+	MinInterval int64 `bencode:"min interval"`
+	// TrackerID should be sent back as trackerid in later announces.
+	TrackerID string `bencode:"tracker id"`
+	// FailureReason is set if the tracker rejected the announce, no other
+	// fields are set then.
+	FailureReason string `bencode:"failure reason"`
+	// WarningMessage is set if the announce succeeded with a warning.
+	WarningMessage string `bencode:"warning message"`
+	// Complete is the number of seeders.
+	Complete int64 `bencode:"complete"`
+	// Incomplete is the number of leechers.
+	Incomplete int64 `bencode:"incomplete"`
+}
+
+// ParseAnnounceResponse decodes the body of an announce response. Trailing
+// bytes are ignored, the same as anacrolix/torrent.
+func ParseAnnounceResponse(body []byte) (*AnnounceResponse, error) {
+	var res AnnounceResponse
+	err := bencode.Unmarshal(body, &res)
+	if _, ok := err.(bencode.ErrUnusedTrailingBytes); ok {
+		err = nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode announce response %q: %w", body, err)
+	}
+	return &res, nil
+}
+
+// IntervalDuration returns Interval as time.Duration.
+func (r *AnnounceResponse) IntervalDuration() time.Duration {
+	return time.Duration(r.Interval) * time.Second
+}
+
+// MinIntervalDuration returns MinInterval as time.Duration.
+func (r *AnnounceResponse) MinIntervalDuration() time.Duration {
+	return time.Duration(r.MinInterval) * time.Second
+}
@@ -0,0 +1,69 @@
+package commons
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAnnounceResponse(t *testing.T) {
+	testCases := []struct {
+		name    string
+		body    string
+		want    *AnnounceResponse
+		wantErr bool
+	}{
+		{
+			name: "all fields",
+			body: "d8:completei5e10:incompletei3e8:intervali1800e12:min intervali900e5:peers0:10:tracker id3:abc15:warning message4:slowe",
+			want: &AnnounceResponse{
+				Interval:       1800,
+				MinInterval:    900,
+				TrackerID:      "abc",
+				WarningMessage: "slow",
+				Complete:       5,
+				Incomplete:     3,
+			},
+		},
+		{
+			name: "failure",
+			body: "d14:failure reason12:unregisterede",
+			want: &AnnounceResponse{FailureReason: "unregistered"},
+		},
+		{
+			name: "trailing bytes",
+			body: "d8:intervali60ee\n",
+			want: &AnnounceResponse{Interval: 60},
+		},
+		{
+			name:    "not bencode",
+			body:    "<html>Bad Gateway</html>",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			body:    "",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ParseAnnounceResponse([]byte(tc.body))
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestAnnounceResponse_Durations(t *testing.T) {
+	r := &AnnounceResponse{Interval: 1800, MinInterval: 900}
+	assert.Equal(t, 30*time.Minute, r.IntervalDuration())
+	assert.Equal(t, 15*time.Minute, r.MinIntervalDuration())
+}
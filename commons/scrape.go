@@ -0,0 +1,182 @@
+package commons
+
+import (
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/madflojo/tasks"
+	"golang.org/x/time/rate"
+)
+
+// Scraper scrapes torrents on trackers periodically, like Transmission, see
+// transmission/scrape.go: the first scrape is scheduled shortly after a torrent
+// is added, then every interval until the torrent is removed. Results are
+// ignored. A nil Scraper never scrapes.
+type Scraper struct {
+	client    *http.Client
+	headers   []Header
+	interval  time.Duration
+	limiter   *rate.Limiter
+	scheduler *tasks.Scheduler
+	now       func() time.Time
+	rand      io.Reader
+
+	// ctx of scrape requests, canceled by Close.
+	ctx    context.Context
+	cancel context.CancelFunc
+	mu     sync.Mutex
+	closed bool
+	// in-flight scrapes.
+	scrapes sync.WaitGroup
+}
+
+// NewScraper creates a Scraper sending scrapes with client and headers, every
+// interval, at most maxPerSecond scrapes per second.
+func NewScraper(client *http.Client, headers []Header, interval time.Duration, maxPerSecond int) *Scraper {
+	s := &Scraper{
+		client:    client,
+		headers:   headers,
+		interval:  interval,
+		limiter:   rate.NewLimiter(rate.Limit(maxPerSecond), maxPerSecond),
+		scheduler: tasks.New(),
+		now:       time.Now,
+		rand:      rand.Reader,
+	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	return s
+}
+
+// SetClock replaces time.Now, for tests. Call it before Add.
+func (s *Scraper) SetClock(now func() time.Time) {
+	s.now = now
+}
+
+// SetRand replaces crypto/rand as the random source of the first scrape delay.
+// Call it before Add.
+func (s *Scraper) SetRand(r io.Reader) {
+	s.rand = r
+}
+
+// Add schedules the scrapes of the torrent on the tracker of announceURL as
+// id. Trackers not supporting scrape are skipped, so are torrents added after
+// Close.
+func (s *Scraper) Add(id string, announceURL *url.URL, infoHash string, trackerQuery TrackerQuery) {
+	if s == nil {
+		return
+	}
+	u := ScrapeURL(announceURL, infoHash, trackerQuery)
+	if u == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	// add some random delay to avoid batch added torrents blocking on rate limiter.
+	delay := time.Duration(RandomUint32From(s.rand)%(9*1000)+1000) * time.Millisecond
+	s.scheduler.AddWithID(id, &tasks.Task{
+		Interval:   s.interval,
+		StartAfter: s.now().Add(delay),
+		TaskFunc: func() error {
+			if !s.start() {
+				return nil
+			}
+			defer s.scrapes.Done()
+			s.scrape(u)
+			return nil
+		},
+	})
+}
+
+// Remove stops the scrapes of id.
+func (s *Scraper) Remove(id string) {
+	if s == nil {
+		return
+	}
+	s.scheduler.Del(id)
+}
+
+// Scheduled reports whether the scrapes of id are scheduled.
+func (s *Scraper) Scheduled(id string) bool {
+	if s == nil {
+		return false
+	}
+	_, err := s.scheduler.Lookup(id)
+	return err == nil
+}
+
+// Close stops scheduled scrapes and cancels in-flight ones. It returns when
+// in-flight scrapes returned, or ctx.Err() if ctx is done first.
+func (s *Scraper) Close(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		s.cancel()
+		s.scheduler.Stop()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.scrapes.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// start reports whether a scrape can start, Close waits for the started ones.
+func (s *Scraper) start() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return false
+	}
+	s.scrapes.Add(1)
+	return true
+}
+
+func (s *Scraper) scrape(u *url.URL) {
+	err := s.limiter.Wait(s.ctx)
+	if err != nil {
+		// Canceled by Close.
+		if s.ctx.Err() == nil {
+			logger.Levelf(log.Error, "Request failed to acquire token %v", err)
+		}
+		return
+	}
+
+	finalURL := u.String()
+
+	req, err := http.NewRequestWithContext(s.ctx, "GET", finalURL, nil)
+	if err != nil {
+		logger.Levelf(log.Error, "Failed to create scrape request for %s: %v", finalURL, err)
+		return
+	}
+	ReplaceHeaders(req, s.headers)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		if s.ctx.Err() == nil {
+			logger.Levelf(log.Info, "Scrape request failed for %s: %v", finalURL, err)
+		}
+		return
+	}
+	resp.Body.Close()
+}
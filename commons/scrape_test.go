@@ -0,0 +1,80 @@
+package commons
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScraper(t *testing.T) {
+	requestReceived := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requestReceived <- r:
+		default:
+		}
+	}))
+	defer server.Close()
+
+	s := NewScraper(server.Client(), []Header{{Name: "User-Agent", Value: "Test/1.0"}}, 10*time.Millisecond, 40)
+	defer s.Close(context.Background())
+	// The first scrape is 1-10s after Add plus the interval.
+	s.SetClock(func() time.Time { return time.Now().Add(-time.Minute) })
+
+	announce, err := url.Parse(server.URL + "/announce")
+	require.NoError(t, err)
+	s.Add("id", announce, "123", TrackerQuery{Before: "auth=a_key"})
+	assert.True(t, s.Scheduled("id"))
+
+	select {
+	case r := <-requestReceived:
+		assert.Equal(t, "/scrape", r.URL.Path)
+		assert.Equal(t, "auth=a_key&info_hash=123", r.URL.RawQuery)
+		assert.Equal(t, "Test/1.0", r.Header.Get("User-Agent"))
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the mock server to receive the scrape request")
+	}
+
+	s.Remove("id")
+	assert.False(t, s.Scheduled("id"))
+
+	noScrape, err := url.Parse(server.URL + "/tracker")
+	require.NoError(t, err)
+	s.Add("id", noScrape, "123", TrackerQuery{})
+	assert.False(t, s.Scheduled("id"), "tracker does not support scrape")
+}
+
+func TestScraper_Close(t *testing.T) {
+	s := NewScraper(http.DefaultClient, nil, time.Hour, 40)
+	announce, err := url.Parse("http://example.com/announce")
+	require.NoError(t, err)
+	s.Add("id", announce, "123", TrackerQuery{})
+	require.True(t, s.start())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Close(ctx), context.DeadlineExceeded, "waits in-flight scrape")
+	assert.False(t, s.Scheduled("id"), "scheduled scrapes are stopped")
+	assert.False(t, s.start(), "no scrape after Close")
+	s.Add("id", announce, "123", TrackerQuery{})
+	assert.False(t, s.Scheduled("id"), "no scrape added after Close")
+
+	s.scrapes.Done()
+	assert.NoError(t, s.Close(context.Background()))
+}
+
+func TestScraper_Nil(t *testing.T) {
+	var s *Scraper
+	announce, err := url.Parse("http://example.com/announce")
+	require.NoError(t, err)
+	s.Add("id", announce, "123", TrackerQuery{})
+	assert.False(t, s.Scheduled("id"))
+	s.Remove("id")
+	assert.NoError(t, s.Close(context.Background()))
+}
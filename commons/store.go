@@ -0,0 +1,258 @@
+package commons
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+const (
+	// fileStoreWriteDelay batches the changes of FileStore into one write.
+	fileStoreWriteDelay = time.Second
+)
+
+// StoredIdentity is the Identity of a torrent on a tracker in IdentityStore.
+type StoredIdentity struct {
+	InfoHash string
+	PeerID   string
+	Key      string
+}
+
+// IdentityStore persists Identities, so a restarted client keeps announcing
+// with the same peer_id and key until the torrent stopped.
+type IdentityStore interface {
+	// Load returns the stored session Identity, nil if not stored, and the
+	// stored Identity of each torrent on each tracker, keyed by
+	// PerTrackerTorrentID.
+	Load() (*Identity, map[string]StoredIdentity, error)
+	SetSession(Identity) error
+	// Put and Delete are called by announces holding the Identities lock,
+	// they should not wait for the disk.
+	Put(id string, identity StoredIdentity) error
+	Delete(id string) error
+	// Close persists everything not persisted yet.
+	Close() error
+}
+
+// MemoryStore is an IdentityStore in memory, it does not survive restarts.
+type MemoryStore struct {
+	mu       sync.Mutex
+	session  *Identity
+	trackers map[string]StoredIdentity
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{trackers: map[string]StoredIdentity{}}
+}
+
+func (s *MemoryStore) Load() (*Identity, map[string]StoredIdentity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	trackers := make(map[string]StoredIdentity, len(s.trackers))
+	for id, identity := range s.trackers {
+		trackers[id] = identity
+	}
+	if s.session == nil {
+		return nil, trackers, nil
+	}
+	session := *s.session
+	return &session, trackers, nil
+}
+
+func (s *MemoryStore) SetSession(identity Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.session = &identity
+	return nil
+}
+
+func (s *MemoryStore) Put(id string, identity StoredIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trackers[id] = identity
+	return nil
+}
+
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.trackers, id)
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore is an IdentityStore in a JSON file. Changes are batched and
+// written by a timer 1s after the first one, and by Close, so announces do not
+// wait for the disk. The file is rewritten each time, it suits the small
+// number of torrents a client has.
+type FileStore struct {
+	path       string
+	writeDelay time.Duration
+
+	mu     sync.Mutex
+	memory *MemoryStore
+	// pending write, nil if there is no change to write.
+	timer *time.Timer
+
+	// held while writing the file, so writes happen in the order of changes.
+	writeMu sync.Mutex
+}
+
+// fileContent is the JSON file. info_hash and peer_id can be binary, all values
+// are bytes (base64 in JSON).
+type fileContent struct {
+	Session  *fileIdentity  `json:"session,omitempty"`
+	Torrents []fileIdentity `json:"torrents"`
+}
+
+type fileIdentity struct {
+	ID       []byte `json:"id,omitempty"`
+	InfoHash []byte `json:"info_hash,omitempty"`
+	PeerID   []byte `json:"peer_id"`
+	Key      []byte `json:"key"`
+}
+
+// NewFileStore opens the store in path, the file is created on first change.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, writeDelay: fileStoreWriteDelay, memory: NewMemoryStore()}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	content := &fileContent{}
+	if err := json.Unmarshal(data, content); err != nil {
+		return nil, fmt.Errorf("invalid identity store %s: %w", path, err)
+	}
+	if content.Session != nil {
+		s.memory.session = &Identity{PeerID: string(content.Session.PeerID), Key: string(content.Session.Key)}
+	}
+	for _, t := range content.Torrents {
+		s.memory.trackers[string(t.ID)] = StoredIdentity{
+			InfoHash: string(t.InfoHash),
+			PeerID:   string(t.PeerID),
+			Key:      string(t.Key),
+		}
+	}
+	return s, nil
+}
+
+func (s *FileStore) Load() (*Identity, map[string]StoredIdentity, error) {
+	return s.memory.Load()
+}
+
+// SetSession writes the file right away, it is set once on start.
+func (s *FileStore) SetSession(identity Identity) error {
+	s.mu.Lock()
+	s.memory.SetSession(identity)
+	s.mu.Unlock()
+	return s.flush()
+}
+
+func (s *FileStore) Put(id string, identity StoredIdentity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory.Put(id, identity)
+	s.scheduleWrite()
+	return nil
+}
+
+func (s *FileStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.memory.Delete(id)
+	s.scheduleWrite()
+	return nil
+}
+
+// Close writes the pending changes, and the file again in case a previous
+// write failed.
+func (s *FileStore) Close() error {
+	return s.flush()
+}
+
+// scheduleWrite writes the file after writeDelay, unless a write is pending.
+// s.mu must be held.
+func (s *FileStore) scheduleWrite() {
+	if s.timer != nil {
+		return
+	}
+	s.timer = time.AfterFunc(s.writeDelay, func() {
+		if err := s.flush(); err != nil {
+			logger.Levelf(log.Error, "Failed to write identity store %s: %v", s.path, err)
+		}
+	})
+}
+
+// flush writes the file with all changes so far.
+func (s *FileStore) flush() error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	data, err := s.marshal()
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return s.write(data)
+}
+
+// marshal encodes the file content. s.mu must be held.
+func (s *FileStore) marshal() ([]byte, error) {
+	session, trackers, _ := s.memory.Load()
+
+	content := &fileContent{Torrents: []fileIdentity{}}
+	if session != nil {
+		content.Session = &fileIdentity{PeerID: []byte(session.PeerID), Key: []byte(session.Key)}
+	}
+	for id, t := range trackers {
+		content.Torrents = append(content.Torrents, fileIdentity{
+			ID:       []byte(id),
+			InfoHash: []byte(t.InfoHash),
+			PeerID:   []byte(t.PeerID),
+			Key:      []byte(t.Key),
+		})
+	}
+	return json.Marshal(content)
+}
+
+// write replaces the file with data atomically.
+func (s *FileStore) write(data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
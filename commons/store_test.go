@@ -0,0 +1,159 @@
+package commons
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIdentityStores(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	fileStore, err := NewFileStore(path)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		store IdentityStore
+	}{
+		{name: "memory", store: NewMemoryStore()},
+		{name: "file", store: fileStore},
+	}
+
+	// info_hash and peer_id can be binary.
+	binary := StoredIdentity{InfoHash: "\xa9\xbfz\xb1\xbb\x05\x91\x9a", PeerID: "-UT355W-\x00\xff\x80", Key: "ABCD"}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			session, trackers, err := tc.store.Load()
+			require.NoError(t, err)
+			assert.Nil(t, session)
+			assert.Empty(t, trackers)
+
+			require.NoError(t, tc.store.SetSession(Identity{PeerID: "session", Key: "key"}))
+			require.NoError(t, tc.store.Put("tracker1--"+binary.InfoHash, binary))
+			require.NoError(t, tc.store.Put("tracker2", StoredIdentity{InfoHash: "hash", PeerID: "p", Key: "k"}))
+			require.NoError(t, tc.store.Delete("tracker2"))
+
+			session, trackers, err = tc.store.Load()
+			require.NoError(t, err)
+			assert.Equal(t, &Identity{PeerID: "session", Key: "key"}, session)
+			assert.Equal(t, map[string]StoredIdentity{"tracker1--" + binary.InfoHash: binary}, trackers)
+		})
+	}
+
+	// Reopen the file.
+	require.NoError(t, fileStore.Close())
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	session, trackers, err := reopened.Load()
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{PeerID: "session", Key: "key"}, session)
+	assert.Equal(t, map[string]StoredIdentity{"tracker1--" + binary.InfoHash: binary}, trackers)
+}
+
+func TestFileStore_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	require.NoError(t, store.SetSession(Identity{PeerID: "session", Key: "key"}))
+
+	// Lost the file, e.g. removed by mistake.
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, store.Close())
+
+	reopened, err := NewFileStore(path)
+	require.NoError(t, err)
+	session, _, err := reopened.Load()
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{PeerID: "session", Key: "key"}, session)
+}
+
+func TestFileStore_BatchesWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	reopen := func() map[string]StoredIdentity {
+		reopened, err := NewFileStore(path)
+		require.NoError(t, err)
+		_, trackers, err := reopened.Load()
+		require.NoError(t, err)
+		return trackers
+	}
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	store.writeDelay = time.Hour
+	require.NoError(t, store.SetSession(Identity{PeerID: "session", Key: "key"}))
+	require.NoError(t, store.Put("tracker1", StoredIdentity{InfoHash: "hash1", PeerID: "p", Key: "k"}))
+	require.NoError(t, store.Put("tracker2", StoredIdentity{InfoHash: "hash2", PeerID: "p", Key: "k"}))
+	assert.Empty(t, reopen(), "changes are not written yet")
+	require.NoError(t, store.Close())
+	assert.Len(t, reopen(), 2)
+
+	// Written by the timer.
+	store.writeDelay = time.Millisecond
+	require.NoError(t, store.Delete("tracker1"))
+	assert.Eventually(t, func() bool { return len(reopen()) == 1 }, 2*time.Second, time.Millisecond)
+}
+
+func TestNewFileStore_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	require.NoError(t, os.WriteFile(path, []byte("{"), 0o600))
+	_, err := NewFileStore(path)
+	assert.ErrorContains(t, err, "invalid identity store")
+}
+
+func TestNewStoredIdentities(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identities.json")
+	created := 0
+	create := func() *Identity {
+		created++
+		return &Identity{PeerID: "-TE0100-" + RandomString(AlphaNumLower, 12), Key: RandomString(AlphaNumLower, 8)}
+	}
+	scopes := Scopes{PeerID: ScopeTorrent, Key: ScopeTracker}
+
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	s, err := NewStoredIdentities(scopes, create, "-TE0100-", store)
+	require.NoError(t, err)
+	pt1, _ := s.LoadOrCreate("tracker1--hash1", "hash1")
+	pt2, _ := s.LoadOrCreate("tracker2--hash1", "hash1")
+	pt3, _ := s.LoadOrCreate("tracker1--hash2", "hash2")
+	s.Delete("tracker1--hash2")
+
+	// Restart.
+	require.NoError(t, s.Close())
+	store, err = NewFileStore(path)
+	require.NoError(t, err)
+	restarted, err := NewStoredIdentities(scopes, create, "-TE0100-", store)
+	require.NoError(t, err)
+	assert.Equal(t, s.Session(), restarted.Session())
+
+	got, exists := restarted.LoadOrCreate("tracker1--hash1", "hash1")
+	assert.False(t, exists, "restored identity is new to this process")
+	assert.Equal(t, pt1, got)
+	got, exists = restarted.LoadOrCreate("tracker1--hash1", "hash1")
+	assert.True(t, exists)
+	assert.Equal(t, pt1, got)
+
+	got, _ = restarted.Load("tracker2--hash1")
+	assert.Equal(t, pt2, got)
+
+	// Stopped torrent is not restored.
+	got, _ = restarted.LoadOrCreate("tracker1--hash2", "hash2")
+	assert.NotEqual(t, pt3, got)
+
+	// New tracker of a restored torrent shares the torrent scope peer_id.
+	got, _ = restarted.LoadOrCreate("tracker3--hash1", "hash1")
+	assert.Equal(t, pt1.PeerID, got.PeerID)
+	assert.NotEqual(t, pt1.Key, got.Key)
+
+	// Stopped expires the stored identity.
+	restarted.Delete("tracker1--hash1")
+	_, trackers, err := store.Load()
+	require.NoError(t, err)
+	assert.NotContains(t, trackers, "tracker1--hash1")
+	assert.Contains(t, trackers, "tracker2--hash1")
+}
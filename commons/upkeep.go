@@ -0,0 +1,62 @@
+package commons
+
+import (
+	"context"
+	"time"
+
+	"github.com/anacrolix/log"
+)
+
+// Upkeep is the lifecycle of a profile scraping like Transmission: it expires
+// idle Identities every interval along with their scrapes, and Close stops both
+// and persists the Identities.
+type Upkeep struct {
+	torrents *Identities
+	// nil if the profile does not scrape.
+	scraper *Scraper
+	logger  log.Logger
+	// expired removes the profile's own state of an expired id, may be nil.
+	expired func(id string)
+	// stops idle expiry.
+	cancel context.CancelFunc
+}
+
+// StartUpkeep expires idle torrents every interval until Close. scraper may be
+// nil, expired is called with each expired id and may be nil.
+func StartUpkeep(logger log.Logger, torrents *Identities, scraper *Scraper, interval time.Duration, expired func(id string)) *Upkeep {
+	u := &Upkeep{
+		torrents: torrents,
+		scraper:  scraper,
+		logger:   logger,
+		expired:  expired,
+	}
+	var ctx context.Context
+	ctx, u.cancel = context.WithCancel(context.Background())
+	go ExpireEvery(ctx, interval, u.ExpireIdle)
+	return u
+}
+
+// ExpireIdle removes idle Identities and their scrapes.
+func (u *Upkeep) ExpireIdle() {
+	expired := u.torrents.Expire()
+	for _, id := range expired {
+		u.scraper.Remove(id)
+		if u.expired != nil {
+			u.expired(id)
+		}
+	}
+	if len(expired) > 0 {
+		u.logger.Levelf(log.Info, "Expired %d idle torrents", len(expired))
+	}
+}
+
+// Close stops idle expiry and scheduled scrapes, cancels in-flight scrapes and
+// persists the Identities. It returns when in-flight scrapes returned, or
+// ctx.Err() if ctx is done first.
+func (u *Upkeep) Close(ctx context.Context) error {
+	u.cancel()
+	if err := u.scraper.Close(ctx); err != nil {
+		return err
+	}
+	return u.torrents.Close()
+}
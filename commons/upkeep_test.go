@@ -0,0 +1,40 @@
+package commons
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpkeep(t *testing.T) {
+	torrents := NewIdentities(Scopes{}, func() *Identity {
+		return &Identity{PeerID: RandomString(AlphaNumLower, 20), Key: "key"}
+	})
+	torrents.SetIdleTTL(time.Millisecond)
+	scraper := NewScraper(http.DefaultClient, nil, time.Hour, 40)
+	var expired []string
+	u := StartUpkeep(logger, torrents, scraper, time.Hour, func(id string) {
+		expired = append(expired, id)
+	})
+
+	announce, err := url.Parse("http://example.com/announce")
+	require.NoError(t, err)
+	torrents.LoadOrCreate("id", "123")
+	scraper.Add("id", announce, "123", TrackerQuery{})
+
+	time.Sleep(5 * time.Millisecond)
+	u.ExpireIdle()
+	_, ok := torrents.Load("id")
+	assert.False(t, ok, "idle identity is removed")
+	assert.False(t, scraper.Scheduled("id"), "idle scrape task is removed")
+	assert.Equal(t, []string{"id"}, expired)
+
+	require.NoError(t, u.Close(context.Background()))
+	scraper.Add("id", announce, "123", TrackerQuery{})
+	assert.False(t, scraper.Scheduled("id"), "scraper is closed")
+}
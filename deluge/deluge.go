@@ -0,0 +1,26 @@
+package deluge
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/libtorrent"
+)
+
+const (
+	delugeV211Bep20     = "-DE211s-"
+	delugeV211UserAgent = "Deluge/2.1.1 libtorrent/2.0.10.0"
+)
+
+// New mimicks Deluge 2.1.1 on libtorrent 2.0.10.
+//
+// Deluge sets peer_fingerprint to "-DE" + version + "s-" (s for stable), and
+// User-Agent to "Deluge/<version> libtorrent/<libtorrent version>".
+func New() *libtorrent.Engine {
+	s, err := libtorrent.New(libtorrent.Profile{
+		Version:   libtorrent.V2_0,
+		Bep20:     delugeV211Bep20,
+		UserAgent: delugeV211UserAgent,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
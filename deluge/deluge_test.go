@@ -0,0 +1,26 @@
+package deluge
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	s := New()
+
+	req, err := http.NewRequest("GET",
+		"http://example.com/tracker/announce?compact=1&downloaded=0&event=started&info_hash=12345678901234567890&key=1234&left=0&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+		nil)
+	require.NoError(t, err)
+	require.NoError(t, s.ChangeHttpRequest(req))
+
+	peerID := req.URL.Query().Get("peer_id")
+	assert.Len(t, peerID, 20)
+	assert.True(t, strings.HasPrefix(peerID, "-DE211s-"))
+	assert.Equal(t, "Deluge/2.1.1 libtorrent/2.0.10.0", req.Header.Get("User-Agent"))
+	assert.Equal(t, "gzip", req.Header.Get("Accept-Encoding"))
+}
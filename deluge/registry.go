@@ -0,0 +1,18 @@
+package deluge
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+func init() {
+	info := commons.ProfileInfo{
+		Client:    "deluge",
+		Version:   "2.1.1",
+		Engine:    "libtorrent-rasterbar 2.0.10",
+		Released:  "2022-07",
+		Protocols: []string{commons.ProtocolHTTP, commons.ProtocolHTTPS, commons.ProtocolUDP},
+	}
+	commons.RegisterProfile(info, func() (commons.Director, error) {
+		return New(), nil
+	})
+}
@@ -1,9 +1,14 @@
 package camouflagetorrentclients
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"sync"
 
 	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
 )
 
 // HttpRequestDirector defines an interface for modifying HTTP requests.
@@ -13,6 +18,19 @@ type HttpRequestDirector interface {
 	ChangeHttpRequest(*http.Request) error
 }
 
+// ClientConfigurer is implemented by profiles to apply the client identity to
+// torrent.ClientConfig, so peers see the same client as trackers.
+type ClientConfigurer interface {
+	ConfigureClient(*torrent.ClientConfig)
+}
+
+// Closer is implemented by profiles running in the background, e.g. scraping.
+type Closer interface {
+	// Close stops the background work and persists the profile state. It
+	// returns when everything stopped, or ctx.Err() if ctx is done first.
+	Close(ctx context.Context) error
+}
+
 // Directors holds a list of HttpRequestDirector implementations.
 type Directors struct {
 	directors []HttpRequestDirector
@@ -35,6 +53,46 @@ func (d *Directors) ChangeHttpRequest(req *http.Request) error {
 	return nil
 }
 
+// ConfigureClient applies the identity of directors implementing
+// ClientConfigurer in order. The last one wins, same as ChangeHttpRequest.
+func (d *Directors) ConfigureClient(cfg *torrent.ClientConfig) {
+	for _, director := range d.directors {
+		if c, ok := director.(ClientConfigurer); ok {
+			c.ConfigureClient(cfg)
+		}
+	}
+}
+
+// ObserveAnnounce hands the announce response to directors implementing
+// AnnounceObserver in order.
+func (d *Directors) ObserveAnnounce(req *http.Request, resp *commons.AnnounceResponse, err error) {
+	for _, director := range d.directors {
+		if o, ok := director.(AnnounceObserver); ok {
+			o.ObserveAnnounce(req, resp, err)
+		}
+	}
+}
+
+// Close closes directors implementing Closer concurrently, and returns when all
+// of them returned.
+func (d *Directors) Close(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(d.directors))
+	for i, director := range d.directors {
+		c, ok := director.(Closer)
+		if !ok {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = c.Close(ctx)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
 var logger = log.NewLogger("announce")
 
 type AnnounceLog struct{}
@@ -43,3 +101,14 @@ func (a *AnnounceLog) ChangeHttpRequest(req *http.Request) error {
 	logger.Levelf(log.Info, "[%s] %s", req.Method, req.URL.String())
 	return nil
 }
+
+func (a *AnnounceLog) ObserveAnnounce(req *http.Request, resp *commons.AnnounceResponse, err error) {
+	switch {
+	case err != nil:
+		logger.Levelf(log.Warning, "announce %s failed: %v", commons.AnnounceURL(req.URL), err)
+	case resp.FailureReason != "":
+		logger.Levelf(log.Warning, "announce %s failed: %s", commons.AnnounceURL(req.URL), resp.FailureReason)
+	case resp.WarningMessage != "":
+		logger.Levelf(log.Info, "announce %s warning: %s", commons.AnnounceURL(req.URL), resp.WarningMessage)
+	}
+}
@@ -1,10 +1,15 @@
 package camouflagetorrentclients
 
 import (
+	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/anacrolix/torrent"
 	"github.com/charleshuang3/camouflagetorrentclients/transmission"
+	"github.com/charleshuang3/camouflagetorrentclients/utorrent"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestNewDirectors(t *testing.T) {
@@ -12,3 +17,37 @@ func TestNewDirectors(t *testing.T) {
 	cfg := torrent.NewDefaultClientConfig()
 	cfg.HttpRequestDirector = d.ChangeHttpRequest
 }
+
+func TestDirectors_ConfigureClient(t *testing.T) {
+	d := NewDirectors(&AnnounceLog{}, utorrent.New(), transmission.New())
+	cfg := torrent.NewDefaultClientConfig()
+	d.ConfigureClient(cfg)
+
+	assert.True(t, strings.HasPrefix(cfg.PeerID, "-TR4060-"))
+	assert.Equal(t, "Transmission/4.0.6", cfg.HTTPUserAgent)
+}
+
+type closeRecorder struct {
+	AnnounceLog
+	err    error
+	closed bool
+}
+
+func (c *closeRecorder) Close(ctx context.Context) error {
+	c.closed = true
+	return c.err
+}
+
+func TestDirectors_Close(t *testing.T) {
+	tr := transmission.New()
+	ok := &closeRecorder{}
+	failed := &closeRecorder{err: errors.New("failed")}
+	d := NewDirectors(&AnnounceLog{}, utorrent.New(), tr, ok, failed)
+
+	err := d.Close(context.Background())
+	assert.ErrorIs(t, err, failed.err)
+	assert.True(t, ok.closed)
+	assert.True(t, failed.closed)
+
+	assert.NoError(t, NewDirectors(tr).Close(context.Background()), "Close twice")
+}
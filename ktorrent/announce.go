@@ -0,0 +1,81 @@
+package ktorrent
+
+import (
+	"strconv"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	ktorrentV520Bep20 = "-KT5200-"
+
+	// libktorrent RandomLetterOrNumber() charset.
+	charSet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+)
+
+var (
+	// KTorrent announces through KIO, which sends its own default Accept-* headers.
+	headers = []commons.Header{
+		{Name: "User-Agent", Value: "ktorrent/5.2.0"},
+		{Name: "Accept", Value: "text/html, text/plain, text/xml, image/gif, image/jpeg, image/png, image/*, */*"},
+		{Name: "Accept-Encoding", Value: "gzip, deflate, x-gzip, x-deflate"},
+		{Name: "Accept-Charset", Value: "utf-8,*;q=0.5"},
+		{Name: "Accept-Language", Value: "en-US,*"},
+	}
+
+	// ktorrent announces in the same fixed order and format as KTorrent 5.2.0
+	// (libktorrent 2.2.0).
+	//
+	// libktorrent adds info_hash as the last query, after all other queries are
+	// encoded. KTorrent does not want peers when stopping.
+	//
+	// https://github.com/KDE/libktorrent/blob/v2.2.0/src/tracker/httptracker.cpp
+	ktorrent = &commons.ClientDef{
+		Name: "ktorrent",
+		Client: commons.ClientIdentity{
+			Bep20:                          ktorrentV520Bep20,
+			ExtendedHandshakeClientVersion: "KTorrent 5.2.0",
+			HTTPUserAgent:                  headers[0].Value,
+			UpnpID:                         "KTorrent",
+		},
+		Headers: headers,
+		QueryDefs: []*commons.QueryDef{
+			commons.MustHaveDef("peer_id"),
+			commons.MustHaveDef("port"),
+			commons.MustHaveDef("uploaded"),
+			commons.MustHaveDef("downloaded"),
+			commons.MustHaveDef("left"),
+			commons.MustHaveDef("compact"),
+			commons.MustHaveDef("numwant"),
+			commons.MustHaveDef("key"),
+			commons.OptionalDef("event"),
+			commons.MustHaveDef("supportcrypto"),
+			commons.OptionalDef("trackerid"),
+			commons.MustHaveDef("info_hash"),
+		},
+		NumWant: commons.NumWant{Announce: 200, Stopped: 0},
+		// anacrolix/torrent has one peer_id per client, handshakes use the
+		// session peer_id.
+		Scopes:      commons.Scopes{PeerID: commons.ScopeTorrent, Key: commons.ScopeTracker},
+		NewIdentity: createPerTorrent,
+	}
+)
+
+// New creates a director announcing as KTorrent 5.2.0.
+func New() *commons.ClientDirector {
+	s, err := commons.NewClientDirector(ktorrent)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func createPerTorrent() *commons.Identity {
+	// peer_id is "-KT5200-" + 12 random letters or numbers. Per torrent.
+
+	// key is random uint32 in decimal. Per tracker.
+	return &commons.Identity{
+		PeerID: ktorrentV520Bep20 + commons.RandomString(charSet, 12),
+		Key:    strconv.FormatUint(uint64(commons.RandomUint32()), 10),
+	}
+}
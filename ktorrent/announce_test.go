@@ -0,0 +1,59 @@
+package ktorrent
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnounce(t *testing.T) {
+	rd := New()
+	cfg := torrent.NewDefaultClientConfig()
+	rd.ConfigureClient(cfg)
+	assert.Equal(t, ktorrentV520Bep20, cfg.Bep20)
+	assert.Equal(t, "KTorrent 5.2.0", cfg.ExtendedHandshakeClientVersion)
+	assert.Equal(t, "KTorrent", cfg.UpnpID)
+
+	testCases := []struct {
+		event   string
+		numwant string
+	}{
+		{event: "started", numwant: "200"},
+		{event: "stopped", numwant: "0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.event, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&event="+tc.event+"&info_hash=123&key=1&left=0&peer_id=OLD&port=3456&supportcrypto=1&uploaded=0", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+			require.NoError(t, rd.ChangeHttpRequest(req))
+
+			assert.Equal(t, http.Header{
+				"User-Agent":      {"ktorrent/5.2.0"},
+				"Accept":          {"text/html, text/plain, text/xml, image/gif, image/jpeg, image/png, image/*, */*"},
+				"Accept-Encoding": {"gzip, deflate, x-gzip, x-deflate"},
+				"Accept-Charset":  {"utf-8,*;q=0.5"},
+				"Accept-Language": {"en-US,*"},
+			}, req.Header)
+
+			q, err := commons.QueryParamsFromRawQueryStr(req.URL.RawQuery)
+			require.NoError(t, err)
+			var names []string
+			for _, p := range q {
+				names = append(names, p.Name)
+			}
+			assert.Equal(t, []string{"peer_id", "port", "uploaded", "downloaded", "left", "compact", "numwant", "key", "event", "supportcrypto", "info_hash"}, names)
+
+			v := req.URL.Query()
+			assert.Equal(t, tc.numwant, v.Get("numwant"))
+			assert.True(t, strings.HasPrefix(v.Get("peer_id"), ktorrentV520Bep20))
+			assert.Regexp(t, `^[0-9]+$`, v.Get("key"))
+		})
+	}
+}
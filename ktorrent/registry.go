@@ -0,0 +1,17 @@
+package ktorrent
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+func init() {
+	info := commons.ProfileInfo{
+		Client:    "ktorrent",
+		Version:   "5.2.0",
+		Engine:    "libktorrent",
+		Protocols: []string{commons.ProtocolHTTP, commons.ProtocolHTTPS, commons.ProtocolUDP},
+	}
+	commons.RegisterProfile(info, func() (commons.Director, error) {
+		return New(), nil
+	})
+}
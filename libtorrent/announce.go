@@ -0,0 +1,255 @@
+package libtorrent
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	// libtorrent url_random() charset.
+	peerIDCharSet = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-_.!~*()"
+)
+
+var (
+	logger = log.NewLogger("libtorrent")
+
+	// anacrolix/torrent does not track corrupt and redundant bytes.
+	v20QueryDefs = []*commons.QueryDef{
+		commons.MustHaveDef("info_hash"),
+		commons.MustHaveDef("peer_id"),
+		commons.MustHaveDef("port"),
+		commons.MustHaveDef("uploaded"),
+		commons.MustHaveDef("downloaded"),
+		commons.MustHaveDef("left"),
+		commons.FixedDef("corrupt", "0"),
+		commons.MustHaveDef("key"),
+		commons.OptionalDef("event"),
+		commons.MustHaveDef("numwant"),
+		commons.MustHaveDef("compact"),
+		commons.FixedDef("no_peer_id", "1"),
+		commons.MustHaveDef("supportcrypto"),
+		commons.FixedDef("redundant", "0"),
+		commons.OptionalDef("trackerid"),
+	}
+
+	// libtorrent 1.2 lists its addresses after all other queries.
+	v12QueryDefs = append(v20QueryDefs[:len(v20QueryDefs):len(v20QueryDefs)],
+		commons.OptionalDef("ipv4"),
+		commons.OptionalDef("ipv6"),
+	)
+)
+
+// Engine builds the announce request query parameters in the same fixed order
+// and format as libtorrent-rasterbar. Clients built on libtorrent, like
+// qBittorrent and Deluge, only differ in peer_id prefix and User-Agent.
+//
+// libtorrent 2.0.10 and 1.2.19:
+//
+// https://github.com/arvidn/libtorrent/blob/v2.0.10/src/http_tracker_connection.cpp
+//
+// https://github.com/arvidn/libtorrent/blob/v1.2.19/src/http_tracker_connection.cpp
+type Engine struct {
+	profile Profile
+	client  commons.ClientIdentity
+	// key of IPv6 announces is the torrent key ^ ipv6KeyMask on libtorrent 2.0.
+	ipv6KeyMask uint32
+	// announce url + info_hash -> peer_id, key
+	torrents *commons.Identities
+	// stops idle expiry.
+	cancel context.CancelFunc
+}
+
+// New creates an Engine announcing as the given Profile. It fails if the
+// identities can not be restored from Profile.Store.
+func New(p Profile) (*Engine, error) {
+	s := &Engine{
+		profile:     p,
+		ipv6KeyMask: commons.RandomUint32(),
+	}
+	s.torrents = commons.NewIdentities(p.scopes(), s.createPerTorrent)
+	if p.Store != nil {
+		var err error
+		if s.torrents, err = commons.NewStoredIdentities(p.scopes(), s.createPerTorrent, p.Bep20, p.Store); err != nil {
+			return nil, err
+		}
+	}
+	// libtorrent uses user_agent as the extended handshake version, and as the
+	// UPnP port mapping description, if handshake_client_version is not set.
+	s.client = commons.ClientIdentity{
+		PeerID:                         s.torrents.Session().PeerID,
+		Bep20:                          p.Bep20,
+		ExtendedHandshakeClientVersion: p.UserAgent,
+		HTTPUserAgent:                  p.UserAgent,
+		UpnpID:                         p.UserAgent,
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go commons.ExpireEvery(ctx, commons.IdleCheckInterval, s.expireIdle)
+	return s, nil
+}
+
+// ConfigureClient applies the client identity. anacrolix/torrent has one
+// peer_id per client, BitTorrent handshakes use the same peer_id as announces
+// only if Profile.Scopes selects commons.ScopeSession peer_id.
+func (s *Engine) ConfigureClient(cfg *torrent.ClientConfig) {
+	s.client.Apply(cfg)
+}
+
+// Close stops idle expiry and persists the identities to Profile.Store.
+func (s *Engine) Close(ctx context.Context) error {
+	s.cancel()
+	return s.torrents.Close()
+}
+
+// SetIdleTTL changes how long a torrent on a tracker is kept without announces,
+// commons.DefaultIdleTTL by default. 0 keeps it until stopped.
+func (s *Engine) SetIdleTTL(ttl time.Duration) {
+	s.torrents.SetIdleTTL(ttl)
+}
+
+// Evicted returns the number of torrents on trackers removed for idle, which
+// were dropped by anacrolix/torrent without announcing stopped.
+func (s *Engine) Evicted() int64 {
+	return s.torrents.Evicted()
+}
+
+// expireIdle removes idle peer_id and key.
+func (s *Engine) expireIdle() {
+	if expired := s.torrents.Expire(); len(expired) > 0 {
+		logger.Levelf(log.Info, "Expired %d idle torrents", len(expired))
+	}
+}
+
+func (s *Engine) ChangeHttpRequest(r *http.Request) error {
+	// Do nothing for scrape request. anacrolix/torrent does not call HttpRequestDirector right now.
+	// Just incase the behavior changed.
+	if commons.IsScrape(r.URL) {
+		return nil
+	}
+
+	err := s.modifyQuery(r)
+	if err != nil {
+		return err
+	}
+	s.modifyHeaders(r)
+	return nil
+}
+
+func (s *Engine) modifyQuery(r *http.Request) error {
+	// RawQuery may contains private tracker's own query, like passkey.
+	trackerQuery, q := commons.SplitAnnounceQuery(r.URL.RawQuery)
+
+	// libtorrent use fixed value for "compact", "supportcrypto", "no_peer_id".
+	// anacrolix/torrent assign fixed value for "compact", "supportcrypto".
+	// Ensure this behavior does not change.
+	if q.Get("compact") != "1" {
+		return fmt.Errorf("anacrolix/torrent provides compact!=1")
+	}
+	if q.Get("supportcrypto") != "1" {
+		return fmt.Errorf("anacrolix/torrent provides supportcrypto!=1")
+	}
+
+	infoHash := q.Get("info_hash")
+	if infoHash == "" {
+		return fmt.Errorf("missing info_hash")
+	}
+	event := q.Get("event")
+
+	id := commons.PerTrackerTorrentID(r.URL, infoHash)
+	pt, exists := s.torrents.LoadOrCreate(id, infoHash)
+	if event == commons.EventStarted {
+		// It is a bug if exists.
+		if exists {
+			logger.Levelf(log.Error, "start a torrent already started")
+		}
+	} else if event == commons.EventStopped {
+		s.torrents.Delete(id)
+	}
+
+	q.Set("peer_id", pt.PeerID)
+	ip := net.ParseIP(r.URL.Hostname())
+	key, err := s.announceKey(pt, isIPv6(ip))
+	if err != nil {
+		return err
+	}
+	q.Set("key", key)
+
+	// libtorrent does not want peers when stopping.
+	if event == commons.EventStopped {
+		q.Set("numwant", "0")
+	} else {
+		q.Set("numwant", strconv.Itoa(s.profile.numWant()))
+	}
+
+	params, err := commons.ProcessQuery(s.profile.queryDefs(), q)
+	if err != nil {
+		return err
+	}
+
+	r.URL.RawQuery = trackerQuery.Join(params.Str())
+
+	// The address family of a tracker hostname is known once dialed, switch to
+	// the IPv6 key then, before the announce is written.
+	if ip == nil && s.profile.Version == V2_0 {
+		ipv6Key, err := s.announceKey(pt, true)
+		if err != nil {
+			return err
+		}
+		commons.OnDial(r, func(conn net.Conn) {
+			addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+			if !ok || !isIPv6(addr.IP) {
+				return
+			}
+			for _, p := range params {
+				if p.Name == "key" {
+					p.Value = ipv6Key
+				}
+			}
+			r.URL.RawQuery = trackerQuery.Join(params.Str())
+		})
+	}
+
+	return nil
+}
+
+// announceKey returns the key of the announce. libtorrent 2.0 announces on each
+// listen socket, the IPv6 one uses a different key so trackers can tell the
+// announces apart.
+func (s *Engine) announceKey(pt *commons.Identity, ipv6 bool) (string, error) {
+	if s.profile.Version == V1_2 || !ipv6 {
+		return pt.Key, nil
+	}
+	key, err := strconv.ParseUint(pt.Key, 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("invalid key %s: %w", pt.Key, err)
+	}
+	return fmt.Sprintf("%08X", uint32(key)^s.ipv6KeyMask), nil
+}
+
+func (s *Engine) modifyHeaders(r *http.Request) {
+	// libtorrent http_connection sends only these headers, and "Connection: close".
+	commons.ReplaceHeaders(r, []commons.Header{
+		{Name: "User-Agent", Value: s.profile.UserAgent},
+		{Name: "Accept-Encoding", Value: "gzip"},
+	})
+}
+
+func (s *Engine) createPerTorrent() *commons.Identity {
+	// https://github.com/arvidn/libtorrent/blob/v2.0.10/src/generate_peer_id.cpp
+	// peer_id is the fingerprint + 12 url_random() chars. libtorrent 1.2 and
+	// 2.0 use a peer_id per torrent.
+
+	// key is random uint32 in 08X format. Per torrent.
+	return &commons.Identity{
+		PeerID: s.profile.Bep20 + commons.RandomString(peerIDCharSet, 12),
+		Key:    fmt.Sprintf("%08X", commons.RandomUint32()),
+	}
+}
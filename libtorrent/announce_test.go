@@ -0,0 +1,373 @@
+package libtorrent
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testBep20     = "-LT20A0-"
+	testUserAgent = "libtorrent/2.0.10.0"
+)
+
+func newTestEngine(t *testing.T) *Engine {
+	return mustNew(t, Profile{
+		Version:   V2_0,
+		Bep20:     testBep20,
+		UserAgent: testUserAgent,
+	})
+}
+
+func mustNew(t *testing.T, p Profile) *Engine {
+	t.Helper()
+	s, err := New(p)
+	require.NoError(t, err)
+	return s
+}
+
+func TestCreatePerTorrent(t *testing.T) {
+	s := newTestEngine(t)
+	previousPeerIDs := make(map[string]bool)
+
+	for i := 0; i < 10; i++ {
+		pt := s.createPerTorrent()
+
+		assert.Len(t, pt.PeerID, 20)
+		assert.True(t, strings.HasPrefix(pt.PeerID, testBep20))
+		for _, char := range pt.PeerID[8:] {
+			assert.True(t, strings.ContainsRune(peerIDCharSet, char), "invalid peer_id char '%c'", char)
+		}
+
+		assert.Len(t, pt.Key, 8)
+		for _, char := range pt.Key {
+			assert.True(t, (char >= '0' && char <= '9') || (char >= 'A' && char <= 'F'), "invalid key char '%c'", char)
+		}
+
+		assert.False(t, previousPeerIDs[pt.PeerID], "Duplicate Peer ID generated: %s", pt.PeerID)
+		previousPeerIDs[pt.PeerID] = true
+	}
+}
+
+func TestHttpRequestDirector_Scrape(t *testing.T) {
+	rd := newTestEngine(t)
+	req, err := http.NewRequest("GET", "http://example.com/tracker/scrape?info_hash=123&unrelated_args=456", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "Teapot/1.0")
+
+	originalURL := req.URL.String()
+	originalHeader := req.Header.Clone()
+
+	err = rd.ChangeHttpRequest(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, originalURL, req.URL.String(), "URL should not be modified for scrape requests")
+	assert.Equal(t, originalHeader, req.Header, "Headers should not be modified for scrape requests")
+}
+
+func TestHttpRequestDirector_Announce(t *testing.T) {
+	infoHash := "%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9"
+	infoHashUnescaped, _ := url.QueryUnescape(infoHash)
+
+	testCases := []struct {
+		name          string
+		rawQuery      string
+		expectedOrder []string
+		numwant       string
+	}{
+		{
+			name: "Public Torrent",
+			rawQuery: fmt.Sprintf(
+				"compact=1&downloaded=0&event=started&info_hash=%s&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+				infoHash),
+			expectedOrder: []string{
+				"info_hash", "peer_id", "port", "uploaded", "downloaded", "left", "corrupt", "key",
+				"event", "numwant", "compact", "no_peer_id", "supportcrypto", "redundant",
+			},
+			numwant: "200",
+		},
+		{
+			name: "Private Torrent",
+			rawQuery: fmt.Sprintf(
+				"auth=123&compact=1&downloaded=0&info_hash=%s&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+				infoHash),
+			expectedOrder: []string{
+				"auth", "info_hash", "peer_id", "port", "uploaded", "downloaded", "left", "corrupt", "key",
+				"numwant", "compact", "no_peer_id", "supportcrypto", "redundant",
+			},
+			numwant: "200",
+		},
+		{
+			name: "Stopped",
+			rawQuery: fmt.Sprintf(
+				"compact=1&downloaded=0&event=stopped&info_hash=%s&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+				infoHash),
+			expectedOrder: []string{
+				"info_hash", "peer_id", "port", "uploaded", "downloaded", "left", "corrupt", "key",
+				"event", "numwant", "compact", "no_peer_id", "supportcrypto", "redundant",
+			},
+			numwant: "0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rd := newTestEngine(t)
+			req, err := http.NewRequest("GET", "http://example.com/tracker/announce?"+tc.rawQuery, nil)
+			require.NoError(t, err)
+			req.Header.Set("User-Agent", "OldAgent/1.0")
+			req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+
+			err = rd.ChangeHttpRequest(req)
+			require.NoError(t, err)
+
+			assert.Equal(t, testUserAgent, req.Header.Get("User-Agent"))
+			assert.Equal(t, "gzip", req.Header.Get("Accept-Encoding"))
+			assert.Len(t, req.Header, 2)
+
+			q, err := commons.QueryParamsFromRawQueryStr(req.URL.RawQuery)
+			require.NoError(t, err)
+			require.Len(t, q, len(tc.expectedOrder))
+
+			for i, expectedName := range tc.expectedOrder {
+				actualParam := q[i]
+				assert.Equal(t, expectedName, actualParam.Name, "Parameter name mismatch at index %d", i)
+
+				switch expectedName {
+				case "auth":
+					assert.Equal(t, "123", actualParam.Value)
+				case "info_hash":
+					assert.Equal(t, infoHashUnescaped, actualParam.Value)
+				case "peer_id":
+					assert.Len(t, actualParam.Value, 20)
+					assert.True(t, strings.HasPrefix(actualParam.Value, testBep20))
+				case "key":
+					assert.Len(t, actualParam.Value, 8)
+				case "numwant":
+					assert.Equal(t, tc.numwant, actualParam.Value)
+				case "corrupt", "redundant":
+					assert.Equal(t, "0", actualParam.Value)
+				case "compact", "no_peer_id", "supportcrypto":
+					assert.Equal(t, "1", actualParam.Value)
+				}
+			}
+		})
+	}
+}
+
+func TestHttpRequestDirector_PerTorrentHandling(t *testing.T) {
+	rd := newTestEngine(t)
+	infoHash := "%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9"
+	infoHashUnescaped, _ := url.QueryUnescape(infoHash)
+	announce := "http://example.com/tracker/announce"
+	rawQuery := fmt.Sprintf(
+		"?compact=1&downloaded=0&event=started&info_hash=%s&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+		infoHash)
+	id := announce + "--" + infoHashUnescaped
+
+	announceOnce := func(rawQuery string) url.Values {
+		req, err := http.NewRequest("GET", announce+rawQuery, nil)
+		require.NoError(t, err)
+		require.NoError(t, rd.ChangeHttpRequest(req))
+		return req.URL.Query()
+	}
+
+	q1 := announceOnce(rawQuery)
+	pt, ok := rd.torrents.Load(id)
+	require.True(t, ok)
+	assert.Equal(t, pt.PeerID, q1.Get("peer_id"))
+	assert.Equal(t, pt.Key, q1.Get("key"))
+
+	q2 := announceOnce(strings.Replace(rawQuery, "event=started&", "", 1))
+	assert.Equal(t, q1.Get("peer_id"), q2.Get("peer_id"), "peer_id should be reused")
+	assert.Equal(t, q1.Get("key"), q2.Get("key"), "key should be reused")
+
+	q3 := announceOnce(strings.Replace(rawQuery, "event=started", "event=stopped", 1))
+	assert.Equal(t, q1.Get("peer_id"), q3.Get("peer_id"), "peer_id should be reused on stopped")
+	_, ok = rd.torrents.Load(id)
+	assert.False(t, ok, "identity should be removed after stopped")
+}
+
+func TestHttpRequestDirector_Versions(t *testing.T) {
+	infoHash := "%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9"
+	rawQuery := fmt.Sprintf(
+		"compact=1&downloaded=0&info_hash=%s&ipv4=10.0.0.1&ipv6=fe80%%3A%%3A1&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+		infoHash)
+
+	testCases := []struct {
+		name          string
+		profile       Profile
+		expectedOrder []string
+		numwant       string
+	}{
+		{
+			name:    "1.2",
+			profile: Profile{Version: V1_2, Bep20: "-LT1290-", UserAgent: "libtorrent/1.2.19.0"},
+			expectedOrder: []string{
+				"info_hash", "peer_id", "port", "uploaded", "downloaded", "left", "corrupt", "key",
+				"numwant", "compact", "no_peer_id", "supportcrypto", "redundant", "ipv4", "ipv6",
+			},
+			numwant: "200",
+		},
+		{
+			name:    "2.0",
+			profile: Profile{Version: V2_0, Bep20: testBep20, UserAgent: testUserAgent, NumWant: 50},
+			expectedOrder: []string{
+				"info_hash", "peer_id", "port", "uploaded", "downloaded", "left", "corrupt", "key",
+				"numwant", "compact", "no_peer_id", "supportcrypto", "redundant",
+			},
+			numwant: "50",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rd := mustNew(t, tc.profile)
+			req, err := http.NewRequest("GET", "http://example.com/tracker/announce?"+rawQuery, nil)
+			require.NoError(t, err)
+			require.NoError(t, rd.ChangeHttpRequest(req))
+
+			assert.Equal(t, tc.profile.UserAgent, req.Header.Get("User-Agent"))
+
+			q, err := commons.QueryParamsFromRawQueryStr(req.URL.RawQuery)
+			require.NoError(t, err)
+			require.Len(t, q, len(tc.expectedOrder))
+			for i, expectedName := range tc.expectedOrder {
+				assert.Equal(t, expectedName, q[i].Name, "Parameter name mismatch at index %d", i)
+			}
+			assert.True(t, strings.HasPrefix(q[1].Value, tc.profile.Bep20))
+			assert.Equal(t, tc.numwant, q[8].Value)
+		})
+	}
+}
+
+// dialedConn is a connection to addr.
+type dialedConn struct {
+	net.Conn
+	addr net.Addr
+}
+
+func (c *dialedConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func TestHttpRequestDirector_IPv6Key(t *testing.T) {
+	rawQuery := "?compact=1&downloaded=0&info_hash=123&key=1234&left=0&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0"
+	pt := &commons.Identity{PeerID: testBep20 + "abcdefghijkl", Key: "0A0B0C0D"}
+
+	testCases := []struct {
+		name     string
+		version  Version
+		announce string
+		// dialed is the tracker address the announce is sent to.
+		dialed        string
+		useTorrentKey bool
+	}{
+		{name: "2.0 IPv4", version: V2_0, announce: "http://127.0.0.1/announce", dialed: "127.0.0.1", useTorrentKey: true},
+		{name: "2.0 hostname IPv4", version: V2_0, announce: "http://example.com/announce", dialed: "93.184.215.14", useTorrentKey: true},
+		{name: "2.0 hostname IPv6", version: V2_0, announce: "http://example.com/announce", dialed: "2001:db8::1", useTorrentKey: false},
+		{name: "2.0 IPv6", version: V2_0, announce: "http://[2001:db8::1]/announce", dialed: "2001:db8::1", useTorrentKey: false},
+		{name: "1.2 hostname IPv6", version: V1_2, announce: "http://example.com/announce", dialed: "2001:db8::1", useTorrentKey: true},
+		{name: "1.2 IPv6", version: V1_2, announce: "http://[2001:db8::1]/announce", dialed: "2001:db8::1", useTorrentKey: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rd := mustNew(t, Profile{Version: tc.version, Bep20: testBep20, UserAgent: testUserAgent})
+			rd.ipv6KeyMask = 0xFFFFFFFF
+			req, err := http.NewRequest("GET", tc.announce+rawQuery, nil)
+			require.NoError(t, err)
+			rd.torrents.LoadOrCreate(commons.PerTrackerTorrentID(req.URL, "123"), "123")
+			stored, ok := rd.torrents.Load(commons.PerTrackerTorrentID(req.URL, "123"))
+			require.True(t, ok)
+			*stored = *pt
+
+			require.NoError(t, rd.ChangeHttpRequest(req))
+			if hook := commons.DialHook(req.Context()); hook != nil {
+				hook(&dialedConn{addr: &net.TCPAddr{IP: net.ParseIP(tc.dialed), Port: 80}})
+			}
+			q := req.URL.Query()
+			assert.Equal(t, pt.PeerID, q.Get("peer_id"))
+			if tc.useTorrentKey {
+				assert.Equal(t, pt.Key, q.Get("key"))
+			} else {
+				assert.Equal(t, "F5F4F3F2", q.Get("key"))
+			}
+		})
+	}
+}
+
+func TestConfigureClient(t *testing.T) {
+	rd := newTestEngine(t)
+	cfg := torrent.NewDefaultClientConfig()
+	rd.ConfigureClient(cfg)
+
+	assert.True(t, strings.HasPrefix(cfg.PeerID, testBep20))
+	assert.Equal(t, testBep20, cfg.Bep20)
+	assert.Equal(t, testUserAgent, cfg.ExtendedHandshakeClientVersion)
+	assert.Equal(t, testUserAgent, cfg.HTTPUserAgent)
+	assert.Equal(t, testUserAgent, cfg.UpnpID)
+
+	// Trackers of the same torrent see consistent values.
+	peerIDs := []string{}
+	keys := []string{}
+	for _, announce := range []string{"http://example.com/announce", "http://another-tracker.com/announce"} {
+		req, err := http.NewRequest("GET", announce+"?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+		require.NoError(t, err)
+		require.NoError(t, rd.ChangeHttpRequest(req))
+		peerIDs = append(peerIDs, req.URL.Query().Get("peer_id"))
+		keys = append(keys, req.URL.Query().Get("key"))
+	}
+	assert.Equal(t, peerIDs[0], peerIDs[1], "peer_id is shared by trackers")
+	assert.Equal(t, keys[0], keys[1], "key is shared by trackers")
+	assert.NotEqual(t, cfg.PeerID, peerIDs[0], "peer_id is per torrent")
+}
+
+func TestStore(t *testing.T) {
+	store := commons.NewMemoryStore()
+	p := Profile{Version: V2_0, Bep20: testBep20, UserAgent: testUserAgent, Store: store}
+	announce := func(rd *Engine, event string) url.Values {
+		req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&"+event+"info_hash=123&key=1&left=0&numwant=50&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+		require.NoError(t, err)
+		require.NoError(t, rd.ChangeHttpRequest(req))
+		return req.URL.Query()
+	}
+
+	q1 := announce(mustNew(t, p), "event=started&")
+
+	// Restart without stopped.
+	rd := mustNew(t, p)
+	q2 := announce(rd, "")
+	assert.Equal(t, q1.Get("peer_id"), q2.Get("peer_id"))
+	assert.Equal(t, q1.Get("key"), q2.Get("key"))
+
+	announce(rd, "event=stopped&")
+	_, trackers, err := store.Load()
+	require.NoError(t, err)
+	assert.Empty(t, trackers, "stopped expires the stored identity")
+
+	q3 := announce(mustNew(t, p), "event=started&")
+	assert.NotEqual(t, q1.Get("peer_id"), q3.Get("peer_id"))
+}
+
+type failingStore struct {
+	*commons.MemoryStore
+}
+
+func (failingStore) Load() (*commons.Identity, map[string]commons.StoredIdentity, error) {
+	return nil, nil, errors.New("corrupt store")
+}
+
+func TestNew_StoreError(t *testing.T) {
+	_, err := New(Profile{Version: V2_0, Bep20: testBep20, UserAgent: testUserAgent, Store: failingStore{commons.NewMemoryStore()}})
+	assert.ErrorContains(t, err, "corrupt store")
+}
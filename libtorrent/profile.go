@@ -0,0 +1,68 @@
+package libtorrent
+
+import (
+	"net"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+// Version is the libtorrent-rasterbar version a client is built on.
+type Version int
+
+const (
+	// V1_2 is libtorrent 1.2.x. It announces once per torrent, and lists the
+	// addresses it knows in "ipv4" and "ipv6".
+	V1_2 Version = iota
+	// V2_0 is libtorrent 2.0.x. It announces once per listen socket, so IPv4 and
+	// IPv6 announces are separated and do not carry "ipv4" / "ipv6".
+	V2_0
+)
+
+const (
+	// DefaultNumWant is settings_pack::num_want default value.
+	DefaultNumWant = 200
+)
+
+// Profile describes a client built on libtorrent-rasterbar. Clients only supply
+// their peer_id prefix and User-Agent, everything else comes from libtorrent.
+type Profile struct {
+	Version Version
+	// Bep20 is the peer_id prefix (settings_pack::peer_fingerprint), e.g. "-qB4650-".
+	Bep20 string
+	// UserAgent is settings_pack::user_agent, e.g. "qBittorrent/4.6.5".
+	UserAgent string
+	// NumWant is settings_pack::num_want, DefaultNumWant if 0.
+	NumWant int
+	// Scopes of peer_id and key, per torrent if nil like libtorrent. Select
+	// commons.ScopeSession peer_id to use the same peer_id in handshakes.
+	Scopes *commons.Scopes
+	// Store persists peer_id and key across restarts, in memory only if nil.
+	Store commons.IdentityStore
+}
+
+func (p *Profile) scopes() commons.Scopes {
+	if p.Scopes == nil {
+		return commons.Scopes{PeerID: commons.ScopeTorrent, Key: commons.ScopeTorrent}
+	}
+	return *p.Scopes
+}
+
+func (p *Profile) numWant() int {
+	if p.NumWant == 0 {
+		return DefaultNumWant
+	}
+	return p.NumWant
+}
+
+func (p *Profile) queryDefs() []*commons.QueryDef {
+	if p.Version == V1_2 {
+		return v12QueryDefs
+	}
+	return v20QueryDefs
+}
+
+// isIPv6 reports whether the announce goes to an IPv6 tracker address.
+// libtorrent 2.0 uses the IPv6 listen socket for it.
+func isIPv6(ip net.IP) bool {
+	return ip != nil && ip.To4() == nil
+}
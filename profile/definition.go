@@ -0,0 +1,362 @@
+package profile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"gopkg.in/yaml.v3"
+)
+
+// Query definition types, see commons.QueryDef.
+const (
+	QueryMustHave = "must_have"
+	QueryOptional = "optional"
+	QueryFixed    = "fixed"
+	QueryCopy     = "copy"
+)
+
+// Scrape policies.
+const (
+	// ScrapeNone never sends scrape request, like most clients.
+	ScrapeNone = "none"
+	// ScrapePeriodic scrapes each torrent on each tracker every interval, like
+	// Transmission.
+	ScrapePeriodic = "periodic"
+)
+
+const (
+	peerIDLen = 20
+
+	defaultScrapeInterval      = 30 * time.Minute
+	defaultMaxScrapesPerSecond = 40
+)
+
+// Definition is a client profile defined in JSON or YAML, so a client can be
+// mimicked without writing Go code. See testdata/ for examples.
+type Definition struct {
+	// Name of the client, e.g. "Transmission 4.0.6".
+	Name string `json:"name" yaml:"name"`
+	// Extends is the name of the base profile, see Resolver. Fields set here
+	// override the base.
+	Extends string    `json:"extends,omitempty" yaml:"extends,omitempty"`
+	PeerID  PeerIDDef `json:"peer_id" yaml:"peer_id"`
+	Key     KeyDef    `json:"key" yaml:"key"`
+	// Query lists the announce query in the order the client sends. Private
+	// tracker's query is always kept at the beginning.
+	Query []QueryDef `json:"query" yaml:"query"`
+	// Headers replace all headers of the announce and scrape requests.
+	Headers []commons.Header `json:"headers" yaml:"headers"`
+	Scrape  ScrapeDef        `json:"scrape" yaml:"scrape"`
+	Client  ClientDef        `json:"client,omitempty" yaml:"client,omitempty"`
+
+	// RemoveQuery and RemoveHeaders drop queries and headers inherited from
+	// the base.
+	RemoveQuery   []string `json:"remove_query,omitempty" yaml:"remove_query,omitempty"`
+	RemoveHeaders []string `json:"remove_headers,omitempty" yaml:"remove_headers,omitempty"`
+}
+
+// PeerIDDef defines how peer_id is generated: Prefix followed by random chars.
+type PeerIDDef struct {
+	// Prefix is the client fingerprint, e.g. "-TR4060-".
+	Prefix string `json:"prefix" yaml:"prefix"`
+	// Alphabet of the random chars, random bytes if empty.
+	Alphabet string `json:"alphabet" yaml:"alphabet"`
+	// Length of the random chars, fill peer_id to 20 bytes if 0. Prefix and
+	// Length must be 20 bytes.
+	Length int `json:"length" yaml:"length"`
+	// Scope is "session", "torrent" or "tracker", "session" if empty. Only
+	// session peer_id is also used in handshakes, see Director.ConfigureClient.
+	Scope string `json:"scope,omitempty" yaml:"scope,omitempty"`
+}
+
+// KeyDef defines how key is generated. Either Format or Alphabet must be set.
+type KeyDef struct {
+	// Format is the printf format of a random uint32, e.g. "%08X".
+	Format string `json:"format" yaml:"format"`
+	// Alphabet and Length generate key from random chars.
+	Alphabet string `json:"alphabet" yaml:"alphabet"`
+	Length   int    `json:"length" yaml:"length"`
+	// Scope is "session", "torrent" or "tracker", "session" if empty.
+	Scope string `json:"scope,omitempty" yaml:"scope,omitempty"`
+}
+
+// QueryDef defines one announce query.
+type QueryDef struct {
+	Name string `json:"name" yaml:"name"`
+	// Type is one of QueryMustHave, QueryOptional, QueryFixed and QueryCopy.
+	Type string `json:"type" yaml:"type"`
+	// Value of QueryFixed.
+	Value string `json:"value,omitempty" yaml:"value,omitempty"`
+	// From is the query QueryCopy copies from.
+	From string `json:"from,omitempty" yaml:"from,omitempty"`
+	// Stopped replaces Value of QueryFixed on stopped event, e.g. numwant=0.
+	Stopped *string `json:"stopped,omitempty" yaml:"stopped,omitempty"`
+}
+
+// ScrapeDef defines the scrape policy.
+type ScrapeDef struct {
+	// Policy is ScrapeNone or ScrapePeriodic, ScrapeNone if empty.
+	Policy string `json:"policy" yaml:"policy"`
+	// Interval of ScrapePeriodic, 30m if 0.
+	Interval Duration `json:"interval,omitempty" yaml:"interval,omitempty"`
+	// MaxPerSecond limits scrape requests of ScrapePeriodic, 40 if 0.
+	MaxPerSecond int `json:"max_per_second,omitempty" yaml:"max_per_second,omitempty"`
+}
+
+// ClientDef defines the identity outside of announces, see
+// commons.ClientIdentity.
+type ClientDef struct {
+	// ExtendedHandshakeVersion is the "v" of the extended handshake, the
+	// User-Agent header if empty.
+	ExtendedHandshakeVersion string `json:"extended_handshake_version,omitempty" yaml:"extended_handshake_version,omitempty"`
+	// UpnpID is the UPnP port mapping description, the profile name if empty.
+	UpnpID string `json:"upnp_id,omitempty" yaml:"upnp_id,omitempty"`
+}
+
+// Duration is time.Duration written as string, e.g. "30m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return time.Duration(d).String(), nil
+}
+
+func (d *Duration) UnmarshalYAML(n *yaml.Node) error {
+	var s string
+	if err := n.Decode(&s); err != nil {
+		return err
+	}
+	return d.parse(s)
+}
+
+func (d *Duration) parse(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// ParseJSON parses a Definition from JSON. Unknown fields are errors, to catch
+// typos.
+func ParseJSON(data []byte) (*Definition, error) {
+	d := &Definition{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf("invalid profile json: %w", err)
+	}
+	return d, nil
+}
+
+// ParseYAML parses a Definition from YAML. Unknown fields are errors, to catch
+// typos.
+func ParseYAML(data []byte) (*Definition, error) {
+	d := &Definition{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(d); err != nil {
+		return nil, fmt.Errorf("invalid profile yaml: %w", err)
+	}
+	return d, nil
+}
+
+// ReadFile reads a Definition from a .json, .yaml or .yml file.
+func ReadFile(path string) (*Definition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return ParseJSON(data)
+	case ".yaml", ".yml":
+		return ParseYAML(data)
+	}
+	return nil, fmt.Errorf("unknown profile format %s", path)
+}
+
+// Validate checks the Definition can build a Director.
+func (d *Definition) Validate() error {
+	if d.Extends != "" {
+		return fmt.Errorf("extends %s is not resolved", d.Extends)
+	}
+	if d.PeerID.Prefix == "" {
+		return fmt.Errorf("peer_id.prefix is required")
+	}
+	// anacrolix/torrent panics on a peer_id not 20 bytes.
+	// Length 0 fills the prefix to 20 bytes.
+	if n := len(d.PeerID.Prefix) + d.PeerID.Length; d.PeerID.Length < 0 || n > peerIDLen || (d.PeerID.Length > 0 && n < peerIDLen) {
+		return fmt.Errorf("peer_id must be %d bytes, got %d", peerIDLen, n)
+	}
+
+	if (d.Key.Format == "") == (d.Key.Alphabet == "") {
+		return fmt.Errorf("key requires either format or alphabet")
+	}
+	if d.Key.Alphabet != "" && d.Key.Length <= 0 {
+		return fmt.Errorf("key.length is required with key.alphabet")
+	}
+	// Wrong verbs or operands are printed as "%!verb(...)".
+	if d.Key.Format != "" && strings.Contains(fmt.Sprintf(d.Key.Format, uint32(0)), "%!") {
+		return fmt.Errorf("key.format %q does not format one uint32", d.Key.Format)
+	}
+	if _, err := d.scopes(); err != nil {
+		return err
+	}
+
+	if len(d.Query) == 0 {
+		return fmt.Errorf("query is required")
+	}
+	seen := map[string]bool{}
+	for i, q := range d.Query {
+		if q.Name == "" {
+			return fmt.Errorf("query[%d] missing name", i)
+		}
+		if seen[q.Name] {
+			return fmt.Errorf("query %s defined twice", q.Name)
+		}
+		seen[q.Name] = true
+
+		switch q.Type {
+		case QueryMustHave, QueryOptional:
+		case QueryFixed:
+		case QueryCopy:
+			if q.From == "" {
+				return fmt.Errorf("query %s: copy requires from", q.Name)
+			}
+		default:
+			return fmt.Errorf("query %s: unknown type %q", q.Name, q.Type)
+		}
+		if q.Stopped != nil && q.Type != QueryFixed {
+			return fmt.Errorf("query %s: stopped only works with fixed", q.Name)
+		}
+	}
+	for _, name := range []string{"info_hash", "peer_id"} {
+		if !seen[name] {
+			return fmt.Errorf("query %s is required", name)
+		}
+	}
+
+	for i, h := range d.Headers {
+		if h.Name == "" {
+			return fmt.Errorf("headers[%d] missing name", i)
+		}
+	}
+
+	switch d.Scrape.Policy {
+	case "", ScrapeNone, ScrapePeriodic:
+	default:
+		return fmt.Errorf("unknown scrape policy %q", d.Scrape.Policy)
+	}
+	if d.Scrape.Interval < 0 || d.Scrape.MaxPerSecond < 0 {
+		return fmt.Errorf("scrape interval and max_per_second must not be negative")
+	}
+
+	return nil
+}
+
+// queryDefs compiles Query to commons.QueryDef. stopped selects the values
+// used on stopped event.
+func (d *Definition) queryDefs(stopped bool) []*commons.QueryDef {
+	defs := make([]*commons.QueryDef, 0, len(d.Query))
+	for _, q := range d.Query {
+		switch q.Type {
+		case QueryMustHave:
+			defs = append(defs, commons.MustHaveDef(q.Name))
+		case QueryOptional:
+			defs = append(defs, commons.OptionalDef(q.Name))
+		case QueryFixed:
+			value := q.Value
+			if stopped && q.Stopped != nil {
+				value = *q.Stopped
+			}
+			defs = append(defs, commons.FixedDef(q.Name, value))
+		case QueryCopy:
+			defs = append(defs, commons.CopyDef(q.Name, q.From))
+		}
+	}
+	return defs
+}
+
+func (d *Definition) scopes() (commons.Scopes, error) {
+	parse := func(field, s string) (commons.Scope, error) {
+		if s == "" {
+			return commons.ScopeSession, nil
+		}
+		scope, err := commons.ParseScope(s)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", field, err)
+		}
+		return scope, nil
+	}
+
+	peerID, err := parse("peer_id.scope", d.PeerID.Scope)
+	if err != nil {
+		return commons.Scopes{}, err
+	}
+	key, err := parse("key.scope", d.Key.Scope)
+	if err != nil {
+		return commons.Scopes{}, err
+	}
+	return commons.Scopes{PeerID: peerID, Key: key}, nil
+}
+
+func (d *Definition) clientIdentity(peerID string) commons.ClientIdentity {
+	c := commons.ClientIdentity{
+		PeerID:                         peerID,
+		Bep20:                          d.PeerID.Prefix,
+		ExtendedHandshakeClientVersion: d.Client.ExtendedHandshakeVersion,
+		UpnpID:                         d.Client.UpnpID,
+	}
+	for _, h := range d.Headers {
+		if http.CanonicalHeaderKey(h.Name) == "User-Agent" {
+			c.HTTPUserAgent = h.Value
+		}
+	}
+	if c.ExtendedHandshakeClientVersion == "" {
+		c.ExtendedHandshakeClientVersion = c.HTTPUserAgent
+	}
+	if c.UpnpID == "" {
+		c.UpnpID = d.Name
+	}
+	return c
+}
+
+func (d *Definition) createPerTorrent() *commons.Identity {
+	n := d.PeerID.Length
+	if n == 0 {
+		n = peerIDLen - len(d.PeerID.Prefix)
+	}
+	pt := &commons.Identity{PeerID: d.PeerID.Prefix}
+	if d.PeerID.Alphabet == "" {
+		pt.PeerID += string(commons.RandomBytes(n))
+	} else {
+		pt.PeerID += commons.RandomString(d.PeerID.Alphabet, n)
+	}
+
+	if d.Key.Format != "" {
+		pt.Key = fmt.Sprintf(d.Key.Format, commons.RandomUint32())
+	} else {
+		pt.Key = commons.RandomString(d.Key.Alphabet, d.Key.Length)
+	}
+	return pt
+}
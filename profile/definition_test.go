@@ -0,0 +1,184 @@
+package profile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadFile(t *testing.T) {
+	testCases := []struct {
+		file           string
+		name           string
+		queries        int
+		headers        int
+		scrapePolicy   string
+		scrapeInterval time.Duration
+	}{
+		{
+			file:           "testdata/transmission-4.0.6.yaml",
+			name:           "Transmission 4.0.6",
+			queries:        14,
+			headers:        3,
+			scrapePolicy:   ScrapePeriodic,
+			scrapeInterval: 30 * time.Minute,
+		},
+		{
+			file:         "testdata/libtorrent-2.0.10.json",
+			name:         "libtorrent 2.0.10",
+			queries:      15,
+			headers:      2,
+			scrapePolicy: ScrapeNone,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			d, err := ReadFile(tc.file)
+			require.NoError(t, err)
+			require.NoError(t, d.Validate())
+
+			assert.Equal(t, tc.name, d.Name)
+			assert.Len(t, d.Query, tc.queries)
+			assert.Len(t, d.Headers, tc.headers)
+			assert.Equal(t, tc.scrapePolicy, d.Scrape.Policy)
+			assert.Equal(t, tc.scrapeInterval, time.Duration(d.Scrape.Interval))
+		})
+	}
+}
+
+func TestReadFile_Errors(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		p := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(p, []byte(content), 0o600))
+		return p
+	}
+
+	_, err := ReadFile(write("p.toml", "name = 1"))
+	assert.ErrorContains(t, err, "unknown profile format")
+
+	_, err = ReadFile(write("p.yaml", "name: a\npeerid: {}\n"))
+	assert.ErrorContains(t, err, "invalid profile yaml")
+
+	_, err = ReadFile(write("p.json", `{"name": "a", "peerid": {}}`))
+	assert.ErrorContains(t, err, "invalid profile json")
+
+	_, err = ReadFile(write("p.yml", "scrape: {interval: soon}\n"))
+	assert.ErrorContains(t, err, "invalid profile yaml")
+}
+
+func TestDefinition_Validate(t *testing.T) {
+	valid := func() *Definition {
+		return &Definition{
+			PeerID: PeerIDDef{Prefix: "-XX0000-"},
+			Key:    KeyDef{Format: "%08X"},
+			Query: []QueryDef{
+				{Name: "info_hash", Type: QueryMustHave},
+				{Name: "peer_id", Type: QueryMustHave},
+			},
+		}
+	}
+	stopped := "0"
+
+	testCases := []struct {
+		name   string
+		modify func(d *Definition)
+		err    string
+	}{
+		{name: "valid", modify: func(d *Definition) {}},
+		{name: "missing prefix", modify: func(d *Definition) { d.PeerID.Prefix = "" }, err: "peer_id.prefix"},
+		{name: "peer_id too long", modify: func(d *Definition) { d.PeerID.Length = 13 }, err: "must be 20 bytes, got 21"},
+		{name: "peer_id too short", modify: func(d *Definition) { d.PeerID.Length = 11 }, err: "must be 20 bytes, got 19"},
+		{name: "peer_id exact length", modify: func(d *Definition) { d.PeerID.Length = 12 }},
+		{name: "prefix too long", modify: func(d *Definition) { d.PeerID.Prefix = strings.Repeat("-", 21) }, err: "must be 20 bytes, got 21"},
+		{name: "negative peer_id length", modify: func(d *Definition) { d.PeerID.Length = -1 }, err: "must be 20 bytes"},
+		{name: "key format string verb", modify: func(d *Definition) { d.Key.Format = "%s" }, err: "key.format"},
+		{name: "key format two verbs", modify: func(d *Definition) { d.Key.Format = "%d%d" }, err: "key.format"},
+		{name: "key format no verb", modify: func(d *Definition) { d.Key.Format = "key" }, err: "key.format"},
+		{name: "key format lower hex", modify: func(d *Definition) { d.Key.Format = "%x" }},
+		{name: "no key", modify: func(d *Definition) { d.Key = KeyDef{} }, err: "either format or alphabet"},
+		{name: "both key", modify: func(d *Definition) { d.Key.Alphabet = "abc" }, err: "either format or alphabet"},
+		{name: "key alphabet no length", modify: func(d *Definition) { d.Key = KeyDef{Alphabet: "abc"} }, err: "key.length"},
+		{name: "no query", modify: func(d *Definition) { d.Query = nil }, err: "query is required"},
+		{name: "missing info_hash", modify: func(d *Definition) { d.Query = d.Query[1:] }, err: "query info_hash is required"},
+		{name: "duplicated query", modify: func(d *Definition) {
+			d.Query = append(d.Query, QueryDef{Name: "peer_id", Type: QueryOptional})
+		}, err: "defined twice"},
+		{name: "unknown type", modify: func(d *Definition) {
+			d.Query = append(d.Query, QueryDef{Name: "port", Type: "required"})
+		}, err: "unknown type"},
+		{name: "copy without from", modify: func(d *Definition) {
+			d.Query = append(d.Query, QueryDef{Name: "azudp", Type: QueryCopy})
+		}, err: "copy requires from"},
+		{name: "stopped on must_have", modify: func(d *Definition) {
+			d.Query = append(d.Query, QueryDef{Name: "numwant", Type: QueryMustHave, Stopped: &stopped})
+		}, err: "stopped only works with fixed"},
+		{name: "header without name", modify: func(d *Definition) {
+			d.Headers = append(d.Headers, commons.Header{Value: "gzip"})
+		}, err: "headers[0] missing name"},
+		{name: "unknown peer_id scope", modify: func(d *Definition) { d.PeerID.Scope = "client" }, err: "peer_id.scope: unknown scope"},
+		{name: "unknown key scope", modify: func(d *Definition) { d.Key.Scope = "peer" }, err: "key.scope: unknown scope"},
+		{name: "unknown scrape policy", modify: func(d *Definition) { d.Scrape.Policy = "sometimes" }, err: "unknown scrape policy"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := valid()
+			tc.modify(d)
+			err := d.Validate()
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
+
+func TestDefinition_CreatePerTorrent(t *testing.T) {
+	testCases := []struct {
+		name     string
+		peerID   PeerIDDef
+		key      KeyDef
+		checkKey func(t *testing.T, key string)
+	}{
+		{
+			name:   "alphabet",
+			peerID: PeerIDDef{Prefix: "-TR4060-", Alphabet: "abc"},
+			key:    KeyDef{Format: "%08X"},
+			checkKey: func(t *testing.T, key string) {
+				assert.Len(t, key, 8)
+			},
+		},
+		{
+			name:   "binary",
+			peerID: PeerIDDef{Prefix: "-UT355W-"},
+			key:    KeyDef{Alphabet: "xyz", Length: 6},
+			checkKey: func(t *testing.T, key string) {
+				assert.Len(t, key, 6)
+				assert.Empty(t, strings.Trim(key, "xyz"))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &Definition{PeerID: tc.peerID, Key: tc.key}
+			pt := d.createPerTorrent()
+
+			assert.Len(t, pt.PeerID, 20)
+			assert.True(t, strings.HasPrefix(pt.PeerID, tc.peerID.Prefix))
+			if tc.peerID.Alphabet != "" {
+				assert.Empty(t, strings.Trim(pt.PeerID[len(tc.peerID.Prefix):], tc.peerID.Alphabet))
+			}
+			tc.checkKey(t, pt.Key)
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+var (
+	logger = log.NewLogger("profile")
+)
+
+// Director rewrites announce requests as described by a Definition.
+type Director struct {
+	def         *Definition
+	client      commons.ClientIdentity
+	queryDefs   []*commons.QueryDef
+	stoppedDefs []*commons.QueryDef
+	// announce url + info_hash -> peer_id, key
+	torrents *commons.Identities
+
+	// nil if the profile does not scrape.
+	scraper *commons.Scraper
+	// idle expiry and Close.
+	upkeep *commons.Upkeep
+}
+
+// New builds a Director from the Definition.
+func New(d *Definition) (*Director, error) {
+	return NewWithStore(d, nil, nil)
+}
+
+// NewWithStore builds a Director from the Definition, restoring peer_id and key
+// from store and sending scrapes with client. Identities are in memory only if
+// store is nil, scrapes are sent with http.DefaultClient if client is nil.
+func NewWithStore(d *Definition, store commons.IdentityStore, client *http.Client) (*Director, error) {
+	if err := d.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid profile %s: %w", d.Name, err)
+	}
+
+	scopes, err := d.scopes()
+	if err != nil {
+		return nil, err
+	}
+	torrents := commons.NewIdentities(scopes, d.createPerTorrent)
+	if store != nil {
+		if torrents, err = commons.NewStoredIdentities(scopes, d.createPerTorrent, d.PeerID.Prefix, store); err != nil {
+			return nil, err
+		}
+	}
+	s := &Director{
+		def:         d,
+		client:      d.clientIdentity(torrents.Session().PeerID),
+		queryDefs:   d.queryDefs(false),
+		stoppedDefs: d.queryDefs(true),
+		torrents:    torrents,
+	}
+	if d.Scrape.Policy == ScrapePeriodic {
+		interval := time.Duration(d.Scrape.Interval)
+		if interval == 0 {
+			interval = defaultScrapeInterval
+		}
+		maxPerSecond := d.Scrape.MaxPerSecond
+		if maxPerSecond == 0 {
+			maxPerSecond = defaultMaxScrapesPerSecond
+		}
+		if client == nil {
+			client = http.DefaultClient
+		}
+		s.scraper = commons.NewScraper(client, d.Headers, interval, maxPerSecond)
+	}
+	s.upkeep = commons.StartUpkeep(logger.WithNames(d.Name), torrents, s.scraper, commons.IdleCheckInterval, nil)
+
+	return s, nil
+}
+
+// Load reads the profile file and builds a Director from it. The base profile
+// it extends is looked up in the same directory.
+func Load(path string) (*Director, error) {
+	d, err := ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if d.Extends == "" {
+		return New(d)
+	}
+
+	r, err := LoadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	return r.New(d.Name)
+}
+
+// Close stops idle expiry and scheduled scrapes, cancels in-flight scrapes and
+// persists the identities to the store. It returns when in-flight scrapes
+// returned, or ctx.Err() if ctx is done first. Announces are still rewritten
+// after Close, without scrapes.
+func (s *Director) Close(ctx context.Context) error {
+	return s.upkeep.Close(ctx)
+}
+
+// SetIdleTTL changes how long a torrent on a tracker is kept without announces,
+// commons.DefaultIdleTTL by default. 0 keeps it until stopped.
+func (s *Director) SetIdleTTL(ttl time.Duration) {
+	s.torrents.SetIdleTTL(ttl)
+}
+
+// Evicted returns the number of torrents on trackers removed for idle, which
+// were dropped by anacrolix/torrent without announcing stopped.
+func (s *Director) Evicted() int64 {
+	return s.torrents.Evicted()
+}
+
+// Name returns the name of the profile.
+func (s *Director) Name() string {
+	return s.def.Name
+}
+
+// ConfigureClient applies the profile identity to the client. anacrolix/torrent
+// has one peer_id per client, BitTorrent handshakes use the same peer_id as
+// announces only with "session" peer_id scope.
+func (s *Director) ConfigureClient(cfg *torrent.ClientConfig) {
+	s.client.Apply(cfg)
+}
+
+func (s *Director) ChangeHttpRequest(r *http.Request) error {
+	// Do nothing for scrape request. anacrolix/torrent does not call HttpRequestDirector right now.
+	// Just incase the behavior changed.
+	if commons.IsScrape(r.URL) {
+		return nil
+	}
+
+	err := s.modifyQuery(r)
+	if err != nil {
+		return err
+	}
+	commons.ReplaceHeaders(r, s.def.Headers)
+	return nil
+}
+
+func (s *Director) modifyQuery(r *http.Request) error {
+	// RawQuery may contains private tracker's own query, like passkey.
+	trackerQuery, q := commons.SplitAnnounceQuery(r.URL.RawQuery)
+
+	infoHash := q.Get("info_hash")
+	if infoHash == "" {
+		return fmt.Errorf("missing info_hash")
+	}
+	event := q.Get("event")
+
+	id := commons.PerTrackerTorrentID(r.URL, infoHash)
+	pt, exists := s.torrents.LoadOrCreate(id, infoHash)
+	if event == commons.EventStarted {
+		// It is a bug if exists.
+		if exists {
+			logger.Levelf(log.Error, "start a torrent already started")
+		}
+	} else if event == commons.EventStopped {
+		s.torrents.Delete(id)
+		s.scraper.Remove(id)
+	}
+
+	if !exists && event != commons.EventStopped {
+		s.scraper.Add(id, r.URL, infoHash, trackerQuery)
+	}
+
+	q.Set("peer_id", pt.PeerID)
+	q.Set("key", pt.Key)
+
+	defs := s.queryDefs
+	if event == commons.EventStopped {
+		defs = s.stoppedDefs
+	}
+	params, err := commons.ProcessQuery(defs, q)
+	if err != nil {
+		return err
+	}
+
+	r.URL.RawQuery = trackerQuery.Join(params.Str())
+
+	return nil
+}
@@ -0,0 +1,364 @@
+package profile
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/charleshuang3/camouflagetorrentclients/libtorrent"
+	"github.com/charleshuang3/camouflagetorrentclients/qbittorrent"
+	"github.com/charleshuang3/camouflagetorrentclients/transmission"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type httpRequestDirector interface {
+	ChangeHttpRequest(r *http.Request) error
+}
+
+func TestDirector_Scrape(t *testing.T) {
+	rd, err := Load("testdata/libtorrent-2.0.10.json")
+	require.NoError(t, err)
+	req, err := http.NewRequest("GET", "http://example.com/tracker/scrape?info_hash=123&unrelated_args=456", nil)
+	require.NoError(t, err)
+	req.Header.Set("User-Agent", "Teapot/1.0")
+
+	originalURL := req.URL.String()
+	originalHeader := req.Header.Clone()
+
+	err = rd.ChangeHttpRequest(req)
+	require.NoError(t, err)
+
+	assert.Equal(t, originalURL, req.URL.String(), "URL should not be modified for scrape requests")
+	assert.Equal(t, originalHeader, req.Header, "Headers should not be modified for scrape requests")
+}
+
+func mustQBittorrent(version string) httpRequestDirector {
+	s, err := qbittorrent.NewVersion(version)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func mustLibtorrent(p libtorrent.Profile) httpRequestDirector {
+	s, err := libtorrent.New(p)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// TestDirector_SameAsBuiltin checks the example profiles produce the same
+// requests as the built-in ones, except the random peer_id and key.
+func TestDirector_SameAsBuiltin(t *testing.T) {
+	infoHash := "%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9"
+
+	testCases := []struct {
+		file    string
+		builtin httpRequestDirector
+	}{
+		{
+			file:    "testdata/transmission-4.0.6.yaml",
+			builtin: transmission.New(),
+		},
+		{
+			file: "testdata/libtorrent-2.0.10.json",
+			builtin: mustLibtorrent(libtorrent.Profile{
+				Version:   libtorrent.V2_0,
+				Bep20:     "-LT20A0-",
+				UserAgent: "libtorrent/2.0.10.0",
+			}),
+		},
+		{
+			file:    "testdata/qbittorrent-5.0.1.yaml",
+			builtin: mustQBittorrent("5.0.1"),
+		},
+	}
+
+	rawQueries := map[string]string{
+		"Private Torrent": fmt.Sprintf(
+			"auth=123&compact=1&downloaded=0&event=started&info_hash=%s&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+			infoHash),
+		"Stopped": fmt.Sprintf(
+			"compact=1&downloaded=0&event=stopped&info_hash=%s&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+			infoHash),
+	}
+
+	for _, tc := range testCases {
+		for name, rawQuery := range rawQueries {
+			t.Run(tc.file+"/"+name, func(t *testing.T) {
+				rd, err := Load(tc.file)
+				require.NoError(t, err)
+				defer rd.Close(context.Background())
+
+				announce := func(d httpRequestDirector) *http.Request {
+					req, err := http.NewRequest("GET", "http://127.0.0.1:1/tracker/announce?"+rawQuery, nil)
+					require.NoError(t, err)
+					req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+					require.NoError(t, d.ChangeHttpRequest(req))
+					return req
+				}
+
+				want := announce(tc.builtin)
+				got := announce(rd)
+
+				assert.Equal(t, want.Header, got.Header)
+
+				wantQuery, err := commons.QueryParamsFromRawQueryStr(want.URL.RawQuery)
+				require.NoError(t, err)
+				gotQuery, err := commons.QueryParamsFromRawQueryStr(got.URL.RawQuery)
+				require.NoError(t, err)
+				require.Len(t, gotQuery, len(wantQuery))
+
+				for i := range wantQuery {
+					assert.Equal(t, wantQuery[i].Name, gotQuery[i].Name, "Parameter name mismatch at index %d", i)
+					switch wantQuery[i].Name {
+					case "peer_id":
+						assert.Len(t, gotQuery[i].Value, 20)
+						assert.Equal(t, wantQuery[i].Value[:8], gotQuery[i].Value[:8])
+					case "key":
+						assert.Len(t, gotQuery[i].Value, len(wantQuery[i].Value))
+					default:
+						assert.Equal(t, wantQuery[i].Value, gotQuery[i].Value, "Parameter %s value mismatch", wantQuery[i].Name)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDirector_PerTorrentHandling(t *testing.T) {
+	rd, err := Load("testdata/libtorrent-2.0.10.json")
+	require.NoError(t, err)
+	infoHash := "%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9"
+	infoHashUnescaped, _ := url.QueryUnescape(infoHash)
+	announce := "http://example.com/tracker/announce"
+	rawQuery := fmt.Sprintf(
+		"?compact=1&downloaded=0&event=started&info_hash=%s&key=1234&left=7159086&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+		infoHash)
+	id := announce + "--" + infoHashUnescaped
+
+	announceOnce := func(rawQuery string) url.Values {
+		req, err := http.NewRequest("GET", announce+rawQuery, nil)
+		require.NoError(t, err)
+		require.NoError(t, rd.ChangeHttpRequest(req))
+		return req.URL.Query()
+	}
+
+	q1 := announceOnce(rawQuery)
+	pt, ok := rd.torrents.Load(id)
+	require.True(t, ok)
+	assert.Equal(t, pt.PeerID, q1.Get("peer_id"))
+	assert.Equal(t, pt.Key, q1.Get("key"))
+	assert.Equal(t, "200", q1.Get("numwant"))
+
+	q2 := announceOnce(strings.Replace(rawQuery, "event=started&", "", 1))
+	assert.Equal(t, q1.Get("peer_id"), q2.Get("peer_id"), "peer_id should be reused")
+	assert.Equal(t, q1.Get("key"), q2.Get("key"), "key should be reused")
+
+	q3 := announceOnce(strings.Replace(rawQuery, "event=started", "event=stopped", 1))
+	assert.Equal(t, q1.Get("peer_id"), q3.Get("peer_id"), "peer_id should be reused on stopped")
+	assert.Equal(t, "0", q3.Get("numwant"))
+	_, ok = rd.torrents.Load(id)
+	assert.False(t, ok, "identity should be removed after stopped")
+}
+
+func TestNewWithStore(t *testing.T) {
+	d, err := ReadFile("testdata/libtorrent-2.0.10.json")
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "identities.json")
+	store, err := commons.NewFileStore(path)
+	require.NoError(t, err)
+	announce := func(rd *Director) url.Values {
+		req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+		require.NoError(t, err)
+		require.NoError(t, rd.ChangeHttpRequest(req))
+		return req.URL.Query()
+	}
+
+	rd, err := NewWithStore(d, store, nil)
+	require.NoError(t, err)
+	q1 := announce(rd)
+
+	// Restart without stopped.
+	require.NoError(t, rd.Close(context.Background()))
+	store, err = commons.NewFileStore(path)
+	require.NoError(t, err)
+	rd, err = NewWithStore(d, store, nil)
+	require.NoError(t, err)
+	q2 := announce(rd)
+	assert.Equal(t, q1.Get("peer_id"), q2.Get("peer_id"))
+	assert.Equal(t, q1.Get("key"), q2.Get("key"))
+}
+
+func TestDirector_PeriodicScrape(t *testing.T) {
+	requestReceived := make(chan struct{}, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/scrape", r.URL.Path)
+		assert.Equal(t, "Transmission/4.0.6", r.Header.Get("User-Agent"))
+		assert.Equal(t, "a_key", r.URL.Query().Get("auth"))
+		assert.Equal(t, "test_info_hash", r.URL.Query().Get("info_hash"))
+
+		select {
+		case requestReceived <- struct{}{}:
+		default:
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	d, err := ReadFile("testdata/transmission-4.0.6.yaml")
+	require.NoError(t, err)
+	d.Scrape.Interval = Duration(10 * time.Millisecond)
+	rd, err := NewWithStore(d, nil, server.Client())
+	require.NoError(t, err)
+	defer rd.Close(context.Background())
+	// The first scrape is 1-10s after the first announce plus the interval.
+	rd.scraper.SetClock(func() time.Time { return time.Now().Add(-time.Minute) })
+
+	// Periodic profile schedules scrape on first announce, and removes it on stopped.
+	req, err := http.NewRequest("GET", server.URL+"/announce?auth=a_key&compact=1&downloaded=0&info_hash=test_info_hash&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	id := commons.PerTrackerTorrentID(req.URL, "test_info_hash")
+	require.NoError(t, rd.ChangeHttpRequest(req))
+	assert.True(t, rd.scraper.Scheduled(id))
+
+	select {
+	case <-requestReceived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the mock server to receive the scrape request")
+	}
+
+	req, err = http.NewRequest("GET", server.URL+"/announce?auth=a_key&compact=1&downloaded=0&event=stopped&info_hash=test_info_hash&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	require.NoError(t, rd.ChangeHttpRequest(req))
+	assert.False(t, rd.scraper.Scheduled(id))
+}
+
+func TestDirector_ExpireIdle(t *testing.T) {
+	rd, err := Load("testdata/transmission-4.0.6.yaml")
+	require.NoError(t, err)
+	rd.SetIdleTTL(time.Millisecond)
+
+	req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	id := commons.PerTrackerTorrentID(req.URL, "123")
+	require.NoError(t, rd.ChangeHttpRequest(req))
+	require.True(t, rd.scraper.Scheduled(id))
+
+	time.Sleep(5 * time.Millisecond)
+	rd.upkeep.ExpireIdle()
+
+	_, ok := rd.torrents.Load(id)
+	assert.False(t, ok, "idle identity is removed")
+	assert.False(t, rd.scraper.Scheduled(id), "idle scrape task is removed")
+	assert.EqualValues(t, 1, rd.Evicted())
+}
+
+func TestDirector_ExpireIdle_NoScrape(t *testing.T) {
+	rd, err := Load("testdata/libtorrent-2.0.10.json")
+	require.NoError(t, err)
+	require.Nil(t, rd.scraper)
+	rd.SetIdleTTL(time.Millisecond)
+
+	req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	id := commons.PerTrackerTorrentID(req.URL, "123")
+	require.NoError(t, rd.ChangeHttpRequest(req))
+
+	time.Sleep(5 * time.Millisecond)
+	rd.upkeep.ExpireIdle()
+
+	_, ok := rd.torrents.Load(id)
+	assert.False(t, ok, "idle identity is removed without scraping")
+	assert.EqualValues(t, 1, rd.Evicted())
+}
+
+func TestDirector_Close(t *testing.T) {
+	rd, err := Load("testdata/transmission-4.0.6.yaml")
+	require.NoError(t, err)
+
+	req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	id := commons.PerTrackerTorrentID(req.URL, "123")
+	require.NoError(t, rd.ChangeHttpRequest(req))
+	assert.True(t, rd.scraper.Scheduled(id))
+
+	require.NoError(t, rd.Close(context.Background()))
+	assert.False(t, rd.scraper.Scheduled(id), "scheduled scrapes are stopped")
+
+	// Announces still work, without scrapes.
+	req, err = http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&info_hash=456&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	require.NoError(t, rd.ChangeHttpRequest(req))
+	assert.False(t, rd.scraper.Scheduled(commons.PerTrackerTorrentID(req.URL, "456")))
+}
+
+func TestDirector_ConfigureClient(t *testing.T) {
+	testCases := []struct {
+		file             string
+		bep20            string
+		handshakeVersion string
+		userAgent        string
+		upnpID           string
+		sessionPeerID    bool
+	}{
+		{
+			file:             "testdata/transmission-4.0.6.yaml",
+			bep20:            "-TR4060-",
+			handshakeVersion: "Transmission 4.0.6",
+			userAgent:        "Transmission/4.0.6",
+			upnpID:           "Transmission",
+			sessionPeerID:    true,
+		},
+		{
+			file:             "testdata/qbittorrent-5.0.1.yaml",
+			bep20:            "-qB5010-",
+			handshakeVersion: "qBittorrent/5.0.1",
+			userAgent:        "qBittorrent/5.0.1",
+			upnpID:           "qBittorrent 5.0.1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			rd, err := Load(tc.file)
+			require.NoError(t, err)
+			defer rd.Close(context.Background())
+
+			cfg := torrent.NewDefaultClientConfig()
+			rd.ConfigureClient(cfg)
+
+			assert.Len(t, cfg.PeerID, 20)
+			assert.True(t, strings.HasPrefix(cfg.PeerID, tc.bep20))
+			assert.Equal(t, tc.bep20, cfg.Bep20)
+			assert.Equal(t, tc.handshakeVersion, cfg.ExtendedHandshakeClientVersion)
+			assert.Equal(t, tc.userAgent, cfg.HTTPUserAgent)
+			assert.Equal(t, tc.upnpID, cfg.UpnpID)
+
+			// Trackers of the same torrent see consistent values.
+			peerIDs := []string{}
+			keys := []string{}
+			for _, announce := range []string{"http://example.com/announce", "http://another-tracker.com/announce"} {
+				req, err := http.NewRequest("GET", announce+"?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+				require.NoError(t, err)
+				require.NoError(t, rd.ChangeHttpRequest(req))
+				peerIDs = append(peerIDs, req.URL.Query().Get("peer_id"))
+				keys = append(keys, req.URL.Query().Get("key"))
+			}
+			assert.Equal(t, peerIDs[0], peerIDs[1], "peer_id is shared by trackers")
+			assert.Equal(t, keys[0], keys[1], "key is shared by trackers")
+			assert.Equal(t, tc.sessionPeerID, cfg.PeerID == peerIDs[0], "handshakes use the announce peer_id only in session scope")
+		})
+	}
+}
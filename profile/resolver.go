@@ -0,0 +1,229 @@
+package profile
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+// Resolver resolves profiles extending other profiles by name.
+//
+// A profile overrides its base as:
+//   - peer_id, client: each non empty field replaces the base's.
+//   - key: replaces the base's if format or alphabet set, scope is separate.
+//   - scrape: replaces the base's if set.
+//   - query, headers: replace the base's one with the same name in place, or
+//     append to the end if the base does not have it. remove_query and
+//     remove_headers drop the base's ones.
+type Resolver struct {
+	defs map[string]*Definition
+}
+
+// Resolved is the effective profile, with the profile supplied each query and
+// header.
+type Resolved struct {
+	Definition *Definition
+	// Query[i] supplied Definition.Query[i].
+	Query []Source
+	// Headers[i] supplied Definition.Headers[i].
+	Headers []Source
+}
+
+// Source tells which profile supplied a query or header.
+type Source struct {
+	Name    string
+	Profile string
+}
+
+func NewResolver() *Resolver {
+	return &Resolver{defs: map[string]*Definition{}}
+}
+
+// LoadDir reads all .json, .yaml and .yml profiles in dir.
+func LoadDir(dir string) (*Resolver, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := NewResolver()
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(e.Name())) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		d, err := ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+		if err := r.Add(d); err != nil {
+			return nil, fmt.Errorf("%s: %w", e.Name(), err)
+		}
+	}
+	return r, nil
+}
+
+// Add adds the profile, names must be unique.
+func (r *Resolver) Add(d *Definition) error {
+	if d.Name == "" {
+		return fmt.Errorf("profile missing name")
+	}
+	if _, ok := r.defs[d.Name]; ok {
+		return fmt.Errorf("profile %s defined twice", d.Name)
+	}
+	r.defs[d.Name] = d
+	return nil
+}
+
+// Resolve returns the effective profile of the given name.
+func (r *Resolver) Resolve(name string) (*Resolved, error) {
+	return r.resolve(name, nil)
+}
+
+// New builds a Director from the effective profile of the given name.
+func (r *Resolver) New(name string) (*Director, error) {
+	res, err := r.Resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	return New(res.Definition)
+}
+
+func (r *Resolver) resolve(name string, chain []string) (*Resolved, error) {
+	if slices.Contains(chain, name) {
+		return nil, fmt.Errorf("profile inheritance cycle: %s -> %s", strings.Join(chain, " -> "), name)
+	}
+	chain = append(chain, name)
+
+	d, ok := r.defs[name]
+	if !ok {
+		if len(chain) > 1 {
+			return nil, fmt.Errorf("profile %s extends unknown profile %s", chain[len(chain)-2], name)
+		}
+		return nil, fmt.Errorf("unknown profile %s", name)
+	}
+
+	if d.Extends == "" {
+		return own(d), nil
+	}
+
+	base, err := r.resolve(d.Extends, chain)
+	if err != nil {
+		return nil, err
+	}
+	return base.extend(d)
+}
+
+// own returns d as Resolved, all supplied by d itself.
+func own(d *Definition) *Resolved {
+	res := &Resolved{Definition: d.clone()}
+	res.Definition.RemoveQuery = nil
+	res.Definition.RemoveHeaders = nil
+	for _, q := range d.Query {
+		res.Query = append(res.Query, Source{Name: q.Name, Profile: d.Name})
+	}
+	for _, h := range d.Headers {
+		res.Headers = append(res.Headers, Source{Name: h.Name, Profile: d.Name})
+	}
+	return res
+}
+
+// extend applies d on top of res.
+func (res *Resolved) extend(d *Definition) (*Resolved, error) {
+	eff := res.Definition
+	eff.Name = d.Name
+
+	if d.PeerID.Prefix != "" {
+		eff.PeerID.Prefix = d.PeerID.Prefix
+	}
+	if d.PeerID.Alphabet != "" {
+		eff.PeerID.Alphabet = d.PeerID.Alphabet
+	}
+	if d.PeerID.Length != 0 {
+		eff.PeerID.Length = d.PeerID.Length
+	}
+	if d.PeerID.Scope != "" {
+		eff.PeerID.Scope = d.PeerID.Scope
+	}
+	if d.Key.Format != "" || d.Key.Alphabet != "" {
+		scope := eff.Key.Scope
+		eff.Key = d.Key
+		eff.Key.Scope = scope
+	}
+	if d.Key.Scope != "" {
+		eff.Key.Scope = d.Key.Scope
+	}
+	if d.Scrape.Policy != "" {
+		eff.Scrape = d.Scrape
+	}
+	if d.Client.ExtendedHandshakeVersion != "" {
+		eff.Client.ExtendedHandshakeVersion = d.Client.ExtendedHandshakeVersion
+	}
+	if d.Client.UpnpID != "" {
+		eff.Client.UpnpID = d.Client.UpnpID
+	}
+
+	queryIndex := func(name string) int {
+		return slices.IndexFunc(eff.Query, func(q QueryDef) bool { return q.Name == name })
+	}
+	for _, name := range d.RemoveQuery {
+		i := queryIndex(name)
+		if i == -1 {
+			return nil, fmt.Errorf("profile %s removes unknown query %s", d.Name, name)
+		}
+		eff.Query = slices.Delete(eff.Query, i, i+1)
+		res.Query = slices.Delete(res.Query, i, i+1)
+	}
+	for _, q := range d.Query {
+		src := Source{Name: q.Name, Profile: d.Name}
+		if i := queryIndex(q.Name); i != -1 {
+			eff.Query[i] = q
+			res.Query[i] = src
+		} else {
+			eff.Query = append(eff.Query, q)
+			res.Query = append(res.Query, src)
+		}
+	}
+
+	// Header names are case insensitive.
+	headerIndex := func(name string) int {
+		name = http.CanonicalHeaderKey(name)
+		return slices.IndexFunc(eff.Headers, func(h commons.Header) bool { return http.CanonicalHeaderKey(h.Name) == name })
+	}
+	for _, name := range d.RemoveHeaders {
+		i := headerIndex(name)
+		if i == -1 {
+			return nil, fmt.Errorf("profile %s removes unknown header %s", d.Name, name)
+		}
+		eff.Headers = slices.Delete(eff.Headers, i, i+1)
+		res.Headers = slices.Delete(res.Headers, i, i+1)
+	}
+	for _, h := range d.Headers {
+		src := Source{Name: h.Name, Profile: d.Name}
+		if i := headerIndex(h.Name); i != -1 {
+			eff.Headers[i] = h
+			res.Headers[i] = src
+		} else {
+			eff.Headers = append(eff.Headers, h)
+			res.Headers = append(res.Headers, src)
+		}
+	}
+
+	return res, nil
+}
+
+func (d *Definition) clone() *Definition {
+	c := *d
+	c.Extends = ""
+	c.Query = slices.Clone(d.Query)
+	c.Headers = slices.Clone(d.Headers)
+	return &c
+}
@@ -0,0 +1,207 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolver_Testdata(t *testing.T) {
+	r, err := LoadDir("testdata")
+	require.NoError(t, err)
+
+	res, err := r.Resolve("qBittorrent 5.0.1")
+	require.NoError(t, err)
+	d := res.Definition
+	require.NoError(t, d.Validate())
+
+	assert.Equal(t, "qBittorrent 5.0.1", d.Name)
+	assert.Equal(t, "-qB5010-", d.PeerID.Prefix)
+	assert.NotEmpty(t, d.PeerID.Alphabet, "alphabet should be inherited")
+	assert.Equal(t, "%08X", d.Key.Format)
+	assert.Equal(t, ScrapeNone, d.Scrape.Policy)
+
+	require.Len(t, res.Query, len(d.Query))
+	for i, src := range res.Query {
+		assert.Equal(t, d.Query[i].Name, src.Name)
+		assert.Equal(t, "libtorrent 2.0.10", src.Profile)
+	}
+	assert.Equal(t, []commons.Header{
+		{Name: "User-Agent", Value: "qBittorrent/5.0.1"},
+		{Name: "Accept-Encoding", Value: "gzip"},
+	}, d.Headers)
+	assert.Equal(t, []Source{
+		{Name: "User-Agent", Profile: "qBittorrent 5.0.1"},
+		{Name: "Accept-Encoding", Profile: "libtorrent 2.0.10"},
+	}, res.Headers)
+
+	// The base is not changed.
+	base, err := r.Resolve("libtorrent 2.0.10")
+	require.NoError(t, err)
+	assert.Equal(t, "libtorrent/2.0.10.0", base.Definition.Headers[0].Value)
+	assert.Equal(t, "-LT20A0-", base.Definition.PeerID.Prefix)
+}
+
+func TestResolver_Override(t *testing.T) {
+	r := NewResolver()
+	require.NoError(t, r.Add(&Definition{
+		Name:   "base",
+		PeerID: PeerIDDef{Prefix: "-BA0000-", Alphabet: "abc"},
+		Key:    KeyDef{Format: "%08X"},
+		Query: []QueryDef{
+			{Name: "info_hash", Type: QueryMustHave},
+			{Name: "peer_id", Type: QueryMustHave},
+			{Name: "numwant", Type: QueryFixed, Value: "200"},
+			{Name: "corrupt", Type: QueryFixed, Value: "0"},
+		},
+		Headers: []commons.Header{
+			{Name: "User-Agent", Value: "base/1.0"},
+			{Name: "Accept-Encoding", Value: "gzip"},
+		},
+	}))
+	require.NoError(t, r.Add(&Definition{
+		Name:    "middle",
+		Extends: "base",
+		Key:     KeyDef{Alphabet: "xyz", Length: 8},
+		Query: []QueryDef{
+			{Name: "numwant", Type: QueryFixed, Value: "50"},
+			{Name: "compact", Type: QueryFixed, Value: "1"},
+		},
+		RemoveHeaders: []string{"accept-encoding"},
+	}))
+	require.NoError(t, r.Add(&Definition{
+		Name:        "leaf",
+		Extends:     "middle",
+		PeerID:      PeerIDDef{Prefix: "-LF0000-"},
+		RemoveQuery: []string{"corrupt"},
+		Headers: []commons.Header{
+			{Name: "user-agent", Value: "leaf/1.0"},
+			{Name: "Accept", Value: "*/*"},
+		},
+		Scrape: ScrapeDef{Policy: ScrapePeriodic},
+	}))
+
+	res, err := r.Resolve("leaf")
+	require.NoError(t, err)
+	d := res.Definition
+	require.NoError(t, d.Validate())
+
+	assert.Equal(t, PeerIDDef{Prefix: "-LF0000-", Alphabet: "abc"}, d.PeerID)
+	assert.Equal(t, KeyDef{Alphabet: "xyz", Length: 8}, d.Key)
+	assert.Equal(t, ScrapePeriodic, d.Scrape.Policy)
+	assert.Empty(t, d.Extends)
+	assert.Empty(t, d.RemoveQuery)
+	assert.Empty(t, d.RemoveHeaders)
+
+	assert.Equal(t, []QueryDef{
+		{Name: "info_hash", Type: QueryMustHave},
+		{Name: "peer_id", Type: QueryMustHave},
+		{Name: "numwant", Type: QueryFixed, Value: "50"},
+		{Name: "compact", Type: QueryFixed, Value: "1"},
+	}, d.Query)
+	assert.Equal(t, []Source{
+		{Name: "info_hash", Profile: "base"},
+		{Name: "peer_id", Profile: "base"},
+		{Name: "numwant", Profile: "middle"},
+		{Name: "compact", Profile: "middle"},
+	}, res.Query)
+
+	assert.Equal(t, []commons.Header{
+		{Name: "user-agent", Value: "leaf/1.0"},
+		{Name: "Accept", Value: "*/*"},
+	}, d.Headers)
+	assert.Equal(t, []Source{
+		{Name: "user-agent", Profile: "leaf"},
+		{Name: "Accept", Profile: "leaf"},
+	}, res.Headers)
+
+	_, err = r.New("leaf")
+	assert.NoError(t, err)
+}
+
+func TestResolver_Errors(t *testing.T) {
+	minimal := func(name, extends string) *Definition {
+		return &Definition{
+			Name:    name,
+			Extends: extends,
+			PeerID:  PeerIDDef{Prefix: "-XX0000-"},
+			Key:     KeyDef{Format: "%08X"},
+			Query: []QueryDef{
+				{Name: "info_hash", Type: QueryMustHave},
+				{Name: "peer_id", Type: QueryMustHave},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		defs    []*Definition
+		resolve string
+		err     string
+	}{
+		{
+			name:    "unknown profile",
+			resolve: "a",
+			err:     "unknown profile a",
+		},
+		{
+			name:    "unknown base",
+			defs:    []*Definition{minimal("a", "b")},
+			resolve: "a",
+			err:     "profile a extends unknown profile b",
+		},
+		{
+			name:    "self cycle",
+			defs:    []*Definition{minimal("a", "a")},
+			resolve: "a",
+			err:     "profile inheritance cycle: a -> a",
+		},
+		{
+			name:    "cycle",
+			defs:    []*Definition{minimal("a", "b"), minimal("b", "c"), minimal("c", "a")},
+			resolve: "a",
+			err:     "profile inheritance cycle: a -> b -> c -> a",
+		},
+		{
+			name: "remove unknown query",
+			defs: []*Definition{minimal("base", ""), func() *Definition {
+				d := minimal("a", "base")
+				d.RemoveQuery = []string{"numwant"}
+				return d
+			}()},
+			resolve: "a",
+			err:     "profile a removes unknown query numwant",
+		},
+		{
+			name: "remove unknown header",
+			defs: []*Definition{minimal("base", ""), func() *Definition {
+				d := minimal("a", "base")
+				d.RemoveHeaders = []string{"Accept"}
+				return d
+			}()},
+			resolve: "a",
+			err:     "profile a removes unknown header Accept",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := NewResolver()
+			for _, d := range tc.defs {
+				require.NoError(t, r.Add(d))
+			}
+			_, err := r.Resolve(tc.resolve)
+			assert.EqualError(t, err, tc.err)
+		})
+	}
+
+	r := NewResolver()
+	require.NoError(t, r.Add(minimal("a", "")))
+	assert.EqualError(t, r.Add(minimal("a", "")), "profile a defined twice")
+	assert.EqualError(t, r.Add(minimal("", "")), "profile missing name")
+
+	_, err := New(minimal("b", "a"))
+	assert.ErrorContains(t, err, "extends a is not resolved")
+}
@@ -0,0 +1,53 @@
+package qbittorrent
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/charleshuang3/camouflagetorrentclients/libtorrent"
+)
+
+const (
+	DefaultVersion = "4.6.5"
+)
+
+var (
+	// qBittorrent versions built on libtorrent 2.0, sorted.
+	supportedVersions = []string{
+		"4.6.0", "4.6.1", "4.6.2", "4.6.3", "4.6.4", "4.6.5", "4.6.6", "4.6.7",
+		"5.0.0", "5.0.1", "5.0.2", "5.0.3", "5.0.4",
+	}
+)
+
+// SupportedVersions returns the qBittorrent versions can be mimicked, sorted.
+func SupportedVersions() []string {
+	return slices.Clone(supportedVersions)
+}
+
+// New mimicks qBittorrent DefaultVersion.
+func New() *libtorrent.Engine {
+	s, err := NewVersion(DefaultVersion)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewVersion mimicks the given qBittorrent version, e.g. "5.0.1".
+func NewVersion(version string) (*libtorrent.Engine, error) {
+	if !slices.Contains(supportedVersions, version) {
+		return nil, fmt.Errorf("unsupported qBittorrent version %s", version)
+	}
+
+	return libtorrent.New(libtorrent.Profile{
+		Version:   libtorrent.V2_0,
+		Bep20:     bep20(version),
+		UserAgent: "qBittorrent/" + version,
+	})
+}
+
+// bep20 returns qBittorrent peer_id prefix, it is
+// lt::generate_fingerprint("qB", major, minor, bugfix, build), build is always 0.
+func bep20(version string) string {
+	return "-qB" + version[0:1] + version[2:3] + version[4:5] + "0-"
+}
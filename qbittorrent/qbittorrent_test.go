@@ -0,0 +1,57 @@
+package qbittorrent
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportedVersions(t *testing.T) {
+	versions := SupportedVersions()
+	assert.True(t, slices.IsSortedFunc(versions, commons.CompareVersions))
+	assert.Contains(t, versions, DefaultVersion)
+
+	// The caller can not change the supported versions.
+	versions[0] = "0.0.0"
+	assert.NotContains(t, SupportedVersions(), "0.0.0")
+}
+
+func TestNewVersion(t *testing.T) {
+	testCases := []struct {
+		version   string
+		bep20     string
+		userAgent string
+		wantErr   bool
+	}{
+		{version: "4.6.5", bep20: "-qB4650-", userAgent: "qBittorrent/4.6.5"},
+		{version: "5.0.1", bep20: "-qB5010-", userAgent: "qBittorrent/5.0.1"},
+		{version: "4.5.0", wantErr: true},
+		{version: "latest", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			s, err := NewVersion(tc.version)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("GET", fmt.Sprintf(
+				"http://example.com/tracker/announce?compact=1&downloaded=0&event=started&info_hash=%s&key=1234&left=0&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+				"12345678901234567890"), nil)
+			require.NoError(t, err)
+			require.NoError(t, s.ChangeHttpRequest(req))
+
+			assert.True(t, strings.HasPrefix(req.URL.Query().Get("peer_id"), tc.bep20))
+			assert.Equal(t, tc.userAgent, req.Header.Get("User-Agent"))
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package qbittorrent
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+// released is the release month of each version.
+var released = map[string]string{
+	"4.6.0": "2023-10",
+	"4.6.1": "2023-11",
+	"4.6.2": "2023-11",
+	"4.6.3": "2024-01",
+	"4.6.4": "2024-03",
+	"4.6.5": "2024-05",
+	"4.6.6": "2024-08",
+	"4.6.7": "2024-09",
+	"5.0.0": "2024-09",
+	"5.0.1": "2024-10",
+	"5.0.2": "2024-11",
+	"5.0.3": "2024-12",
+	"5.0.4": "2025-02",
+}
+
+func init() {
+	for _, v := range SupportedVersions() {
+		info := commons.ProfileInfo{
+			Client:    "qbittorrent",
+			Version:   v,
+			Engine:    "libtorrent-rasterbar 2.0",
+			Released:  released[v],
+			Protocols: []string{commons.ProtocolHTTP, commons.ProtocolHTTPS, commons.ProtocolUDP},
+		}
+		commons.RegisterProfile(info, func() (commons.Director, error) {
+			s, err := NewVersion(v)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package camouflagetorrentclients
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+
+	// Built-in profiles register themselves.
+	_ "github.com/charleshuang3/camouflagetorrentclients/aria2"
+	_ "github.com/charleshuang3/camouflagetorrentclients/biglybt"
+	_ "github.com/charleshuang3/camouflagetorrentclients/deluge"
+	_ "github.com/charleshuang3/camouflagetorrentclients/ktorrent"
+	_ "github.com/charleshuang3/camouflagetorrentclients/qbittorrent"
+	_ "github.com/charleshuang3/camouflagetorrentclients/rtorrent"
+	_ "github.com/charleshuang3/camouflagetorrentclients/tixati"
+	_ "github.com/charleshuang3/camouflagetorrentclients/transmission"
+	_ "github.com/charleshuang3/camouflagetorrentclients/utorrent"
+)
+
+// Lookup creates the profile of name "client/version", e.g.
+// "transmission/4.0.6". Version "latest" or no version picks the newest one,
+// e.g. "qbittorrent/latest". See Profiles for all names.
+func Lookup(name string) (HttpRequestDirector, error) {
+	d, _, err := commons.LookupProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Profiles returns the profiles can be looked up, sorted by client and version.
+// Other profiles can be added by commons.RegisterProfile.
+func Profiles() []commons.ProfileInfo {
+	return commons.Profiles()
+}
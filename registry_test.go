@@ -0,0 +1,55 @@
+package camouflagetorrentclients
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookup(t *testing.T) {
+	testCases := []struct {
+		name      string
+		userAgent string
+	}{
+		{name: "transmission/4.0.6", userAgent: "Transmission/4.0.6"},
+		{name: "transmission/latest", userAgent: "Transmission/4.1.0"},
+		{name: "qbittorrent/latest", userAgent: "qBittorrent/5.0.4"},
+		{name: "qBittorrent/4.6.5", userAgent: "qBittorrent/4.6.5"},
+		{name: "deluge", userAgent: "Deluge/2.1.1 libtorrent/2.0.10.0"},
+		{name: "utorrent/3.5.5", userAgent: "uTorrent/355(45852)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Lookup(tc.name)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+			require.NoError(t, err)
+			require.NoError(t, d.ChangeHttpRequest(req))
+			assert.Equal(t, tc.userAgent, req.Header.Get("User-Agent"))
+		})
+	}
+
+	_, err := Lookup("transmission/2.94")
+	assert.Error(t, err)
+	_, err = Lookup("bitcomet/latest")
+	assert.Error(t, err)
+}
+
+func TestProfiles(t *testing.T) {
+	names := map[string]bool{}
+	for _, p := range Profiles() {
+		names[p.Name()] = true
+		assert.NotEmpty(t, p.Engine, p.Name())
+		assert.NotEmpty(t, p.Protocols, p.Name())
+	}
+	for _, name := range []string{
+		"aria2/1.37.0", "biglybt/3.5.0.0", "deluge/2.1.1", "ktorrent/5.2.0", "qbittorrent/5.0.1",
+		"rtorrent/0.9.8", "tixati/3.28", "transmission/3.00", "transmission/4.1.0", "utorrent/3.5.5",
+	} {
+		assert.True(t, names[name], "%s not registered", name)
+	}
+}
@@ -0,0 +1,74 @@
+package rtorrent
+
+import (
+	"fmt"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	// libtorrent (rakshasa) 0.13.8, "D" is 13.
+	libtorrentV0138Bep20 = "-lt0D80-"
+)
+
+var (
+	// rTorrent announces with libcurl, Accept-Encoding is every encoding curl supports.
+	headers = []commons.Header{
+		{Name: "User-Agent", Value: "rtorrent/0.9.8/0.13.8"},
+		{Name: "Accept", Value: "*/*"},
+		{Name: "Accept-Encoding", Value: "deflate, gzip"},
+	}
+
+	// rtorrent announces in the same fixed order and format as rTorrent 0.9.8,
+	// which announces through libtorrent (rakshasa) 0.13.8.
+	//
+	// rTorrent only sends "numwant" when trackers.numwant is set, it is -1 by
+	// default. rTorrent does not send "supportcrypto".
+	//
+	// https://github.com/rakshasa/libtorrent/blob/v0.13.8/src/tracker/tracker_http.cc
+	rtorrent = &commons.ClientDef{
+		Name: "rtorrent",
+		Client: commons.ClientIdentity{
+			Bep20:                          libtorrentV0138Bep20,
+			ExtendedHandshakeClientVersion: "libTorrent 0.13.8",
+			HTTPUserAgent:                  headers[0].Value,
+			UpnpID:                         "rTorrent",
+		},
+		Headers: headers,
+		QueryDefs: []*commons.QueryDef{
+			commons.MustHaveDef("info_hash"),
+			commons.MustHaveDef("peer_id"),
+			commons.MustHaveDef("key"),
+			commons.OptionalDef("trackerid"),
+			commons.MustHaveDef("compact"),
+			commons.MustHaveDef("port"),
+			commons.MustHaveDef("uploaded"),
+			commons.MustHaveDef("downloaded"),
+			commons.MustHaveDef("left"),
+			commons.OptionalDef("event"),
+		},
+		// anacrolix/torrent has one peer_id per client, handshakes use the
+		// session peer_id.
+		Scopes:      commons.Scopes{PeerID: commons.ScopeTorrent, Key: commons.ScopeTorrent},
+		NewIdentity: createPerTorrent,
+	}
+)
+
+// New creates a director announcing as rTorrent 0.9.8.
+func New() *commons.ClientDirector {
+	s, err := commons.NewClientDirector(rtorrent)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func createPerTorrent() *commons.Identity {
+	// peer_id is "-lt0D80-" + 12 random bytes. Per download.
+
+	// key is random uint32 in 08x format. Per download.
+	return &commons.Identity{
+		PeerID: libtorrentV0138Bep20 + string(commons.RandomBytes(12)),
+		Key:    fmt.Sprintf("%08x", commons.RandomUint32()),
+	}
+}
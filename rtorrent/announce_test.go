@@ -0,0 +1,56 @@
+package rtorrent
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnounce(t *testing.T) {
+	rd := New()
+	cfg := torrent.NewDefaultClientConfig()
+	rd.ConfigureClient(cfg)
+	assert.Equal(t, libtorrentV0138Bep20, cfg.Bep20)
+	assert.Equal(t, "libTorrent 0.13.8", cfg.ExtendedHandshakeClientVersion)
+	assert.Equal(t, "rTorrent", cfg.UpnpID)
+
+	testCases := []struct {
+		event string
+	}{
+		{event: "started"},
+		{event: "stopped"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.event, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&event="+tc.event+"&info_hash=123&key=1&left=0&peer_id=OLD&port=3456&supportcrypto=1&uploaded=0", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+			require.NoError(t, rd.ChangeHttpRequest(req))
+
+			assert.Equal(t, http.Header{
+				"User-Agent":      {"rtorrent/0.9.8/0.13.8"},
+				"Accept":          {"*/*"},
+				"Accept-Encoding": {"deflate, gzip"},
+			}, req.Header)
+
+			q, err := commons.QueryParamsFromRawQueryStr(req.URL.RawQuery)
+			require.NoError(t, err)
+			var names []string
+			for _, p := range q {
+				names = append(names, p.Name)
+			}
+			assert.Equal(t, []string{"info_hash", "peer_id", "key", "compact", "port", "uploaded", "downloaded", "left", "event"}, names)
+
+			v := req.URL.Query()
+			assert.False(t, v.Has("numwant"))
+			assert.True(t, strings.HasPrefix(v.Get("peer_id"), libtorrentV0138Bep20))
+			assert.Regexp(t, `^[0-9a-f]{8}$`, v.Get("key"))
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package rtorrent
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+func init() {
+	info := commons.ProfileInfo{
+		Client:    "rtorrent",
+		Version:   "0.9.8",
+		Engine:    "libtorrent 0.13.8",
+		Released:  "2019-07",
+		Protocols: []string{commons.ProtocolHTTP, commons.ProtocolHTTPS, commons.ProtocolUDP},
+	}
+	commons.RegisterProfile(info, func() (commons.Director, error) {
+		return New(), nil
+	})
+}
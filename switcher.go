@@ -0,0 +1,353 @@
+package camouflagetorrentclients
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+// Switcher rewrites announces with a profile per tracker or per torrent, and
+// moves them to another profile at runtime, e.g. when a tracker drops a client
+// version from its whitelist.
+//
+// Switching sends a stopped announce with the old profile, then a started
+// announce with the new one, so the tracker never sees both identities active.
+// A torrent failing to stop stays on the old profile, and is moved by the next
+// switch.
+type Switcher struct {
+	client *http.Client
+
+	mu  sync.Mutex
+	def HttpRequestDirector
+	// announce url -> profile
+	trackers map[string]HttpRequestDirector
+	// info_hash -> profile, wins over trackers.
+	torrents map[string]HttpRequestDirector
+	// announce url + info_hash -> last announce not stopped.
+	announces map[string]*lastAnnounce
+	// 0 never expires.
+	idleTTL time.Duration
+	// stops idle expiry.
+	cancel context.CancelFunc
+}
+
+type lastAnnounce struct {
+	// tracker and infoHash never change, read without mu.
+	tracker  *url.URL
+	infoHash string
+	// guarded by Switcher.mu.
+	lastSeen time.Time
+
+	// held while the torrent announces or switches profile.
+	mu sync.Mutex
+	// announce from anacrolix/torrent, before rewriting.
+	url     *url.URL
+	profile HttpRequestDirector
+	// stats when switched to profile, see withStats.
+	base    stats
+	stopped bool
+}
+
+// stats are the uploaded and downloaded bytes of an announce.
+type stats struct {
+	uploaded   int64
+	downloaded int64
+}
+
+// profileKey is the context key of the profile rewrote the request.
+type profileKey struct{}
+
+const (
+	// switchAnnounceTimeout limits each stopped and started announce sent by
+	// switching.
+	switchAnnounceTimeout = 30 * time.Second
+)
+
+// NewSwitcher creates a Switcher rewriting announces with def, until switched.
+// Switching announces are sent the same way as anacrolix/torrent announces,
+// with cfg.TrackerDialContext and cfg.HTTPProxy, set them before NewSwitcher.
+// Each one times out after 30s. Their responses are handed to the profiles
+// directly.
+func NewSwitcher(def HttpRequestDirector, cfg *torrent.ClientConfig) *Switcher {
+	s := &Switcher{
+		client:    newTrackerClient(cfg),
+		def:       def,
+		trackers:  map[string]HttpRequestDirector{},
+		torrents:  map[string]HttpRequestDirector{},
+		announces: map[string]*lastAnnounce{},
+		idleTTL:   commons.DefaultIdleTTL,
+	}
+	var ctx context.Context
+	ctx, s.cancel = context.WithCancel(context.Background())
+	go commons.ExpireEvery(ctx, commons.IdleCheckInterval, s.expireIdle)
+	return s
+}
+
+// newTrackerClient creates an http.Client configured the same as the one
+// anacrolix/torrent announces with.
+func newTrackerClient(cfg *torrent.ClientConfig) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext:       cfg.TrackerDialContext,
+		Proxy:             cfg.HTTPProxy,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+}
+
+// SetIdleTTL changes how long a torrent on a tracker is kept without announces,
+// commons.DefaultIdleTTL by default like the profiles' identities. 0 keeps it
+// until stopped. Expired torrents are not moved by switching.
+func (s *Switcher) SetIdleTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.idleTTL = ttl
+}
+
+// expireIdle forgets torrents dropped by anacrolix/torrent without announcing
+// stopped.
+func (s *Switcher) expireIdle() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.idleTTL <= 0 {
+		return
+	}
+	for id, a := range s.announces {
+		if time.Since(a.lastSeen) > s.idleTTL {
+			delete(s.announces, id)
+		}
+	}
+}
+
+// ChangeHttpRequest rewrites the announce with the profile of the torrent on the
+// tracker. Announces of a torrent wait for its switch to finish, others do not.
+func (s *Switcher) ChangeHttpRequest(r *http.Request) error {
+	if commons.IsScrape(r.URL) {
+		return nil
+	}
+	q := r.URL.Query()
+	infoHash := q.Get("info_hash")
+	if infoHash == "" {
+		return fmt.Errorf("missing info_hash")
+	}
+	stopped := q.Get("event") == commons.EventStopped
+
+	id := commons.PerTrackerTorrentID(r.URL, infoHash)
+	s.mu.Lock()
+	a, ok := s.announces[id]
+	if !ok {
+		u := *r.URL
+		a = &lastAnnounce{tracker: &u, infoHash: infoHash, profile: s.route(r.URL, infoHash)}
+	}
+	if stopped {
+		delete(s.announces, id)
+	} else {
+		a.lastSeen = time.Now()
+		s.announces[id] = a
+	}
+	s.mu.Unlock()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	u := *r.URL
+	a.url = &u
+	a.stopped = stopped
+	r.URL = withStats(r.URL, a.base)
+	*r = *r.WithContext(context.WithValue(r.Context(), profileKey{}, a.profile))
+	return a.profile.ChangeHttpRequest(r)
+}
+
+// route returns the profile of the torrent on the tracker.
+func (s *Switcher) route(u *url.URL, infoHash string) HttpRequestDirector {
+	if p, ok := s.torrents[infoHash]; ok {
+		return p
+	}
+	if p, ok := s.trackers[commons.AnnounceURL(u)]; ok {
+		return p
+	}
+	return s.def
+}
+
+// ObserveAnnounce hands the announce response to the profile rewrote the
+// request, if it implements AnnounceObserver. Requests not rewritten by the
+// Switcher go to the profile the announce routes to.
+func (s *Switcher) ObserveAnnounce(req *http.Request, resp *commons.AnnounceResponse, err error) {
+	profile, ok := req.Context().Value(profileKey{}).(HttpRequestDirector)
+	if !ok {
+		s.mu.Lock()
+		profile = s.route(req.URL, req.URL.Query().Get("info_hash"))
+		s.mu.Unlock()
+	}
+	observeAnnounce(profile, req, resp, err)
+}
+
+// SwitchTracker moves all torrents on the tracker to profile, except torrents
+// switched by SwitchTorrent. announceURL is the announce URL without query.
+func (s *Switcher) SwitchTracker(ctx context.Context, announceURL string, profile HttpRequestDirector) error {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.trackers[commons.AnnounceURL(u)] = profile
+	s.mu.Unlock()
+	return s.migrate(ctx)
+}
+
+// SwitchTorrent moves the torrent on all trackers to profile. infoHash is the
+// raw 20 bytes info_hash.
+func (s *Switcher) SwitchTorrent(ctx context.Context, infoHash string, profile HttpRequestDirector) error {
+	s.mu.Lock()
+	s.torrents[infoHash] = profile
+	s.mu.Unlock()
+	return s.migrate(ctx)
+}
+
+// migrate re-announces the torrents not on the profile they route to now.
+func (s *Switcher) migrate(ctx context.Context) error {
+	type move struct {
+		a  *lastAnnounce
+		to HttpRequestDirector
+	}
+	s.mu.Lock()
+	var moves []move
+	for _, a := range s.announces {
+		moves = append(moves, move{a: a, to: s.route(a.tracker, a.infoHash)})
+	}
+	s.mu.Unlock()
+
+	var errs []error
+	for _, m := range moves {
+		if err := s.move(ctx, m.a, m.to); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// move announces stopped with the torrent's profile, then started with to. The
+// torrent stays on its profile if stopped fails.
+func (s *Switcher) move(ctx context.Context, a *lastAnnounce, to HttpRequestDirector) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.stopped || a.profile == to {
+		return nil
+	}
+	if err := s.announce(ctx, a.profile, withStats(a.url, a.base), commons.EventStopped); err != nil {
+		return fmt.Errorf("stop %s: %w", commons.AnnounceURL(a.url), err)
+	}
+	a.profile = to
+	a.base = readStats(a.url)
+	if err := s.announce(ctx, to, withStats(a.url, a.base), commons.EventStarted); err != nil {
+		return fmt.Errorf("start %s: %w", commons.AnnounceURL(a.url), err)
+	}
+	return nil
+}
+
+// announce sends the announce u with event, rewritten by profile.
+func (s *Switcher) announce(ctx context.Context, profile HttpRequestDirector, u *url.URL, event string) error {
+	ctx, cancel := context.WithTimeout(ctx, switchAnnounceTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", withEvent(u, event).String(), nil)
+	if err != nil {
+		return err
+	}
+	if err := profile.ChangeHttpRequest(req); err != nil {
+		return err
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		observeAnnounce(profile, req, nil, err)
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		observeAnnounce(profile, req, nil, err)
+		return err
+	}
+	ar, err := parseAnnounceResponse(resp, body)
+	observeAnnounce(profile, req, ar, err)
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("tracker responded %s", resp.Status)
+	}
+	return nil
+}
+
+// withEvent returns u with the event replaced, encoded the same way as
+// anacrolix/torrent.
+func withEvent(u *url.URL, event string) *url.URL {
+	res := *u
+	trackerQuery, q := commons.SplitAnnounceQuery(u.RawQuery)
+	q.Set("event", event)
+	res.RawQuery = trackerQuery.Join(q.Encode())
+	return &res
+}
+
+// readStats returns the uploaded and downloaded bytes of the announce u.
+func readStats(u *url.URL) stats {
+	q := u.Query()
+	uploaded, _ := strconv.ParseInt(q.Get("uploaded"), 10, 64)
+	downloaded, _ := strconv.ParseInt(q.Get("downloaded"), 10, 64)
+	return stats{uploaded: uploaded, downloaded: downloaded}
+}
+
+// withStats returns u with uploaded and downloaded counted from base, the
+// stats when the torrent switched to its profile. anacrolix/torrent keeps
+// counting from the torrent start, but the new peer_id starts at 0, so the
+// tracker does not credit the bytes of the old peer_id twice.
+func withStats(u *url.URL, base stats) *url.URL {
+	if base == (stats{}) {
+		return u
+	}
+	cur := readStats(u)
+	res := *u
+	trackerQuery, q := commons.SplitAnnounceQuery(u.RawQuery)
+	q.Set("uploaded", strconv.FormatInt(max(cur.uploaded-base.uploaded, 0), 10))
+	q.Set("downloaded", strconv.FormatInt(max(cur.downloaded-base.downloaded, 0), 10))
+	res.RawQuery = trackerQuery.Join(q.Encode())
+	return &res
+}
+
+// ConfigureClient applies the identity of the default profile, if it
+// implements ClientConfigurer.
+func (s *Switcher) ConfigureClient(cfg *torrent.ClientConfig) {
+	if c, ok := s.def.(ClientConfigurer); ok {
+		c.ConfigureClient(cfg)
+	}
+}
+
+// Close stops idle expiry and closes all profiles implementing Closer, see
+// Directors.Close.
+func (s *Switcher) Close(ctx context.Context) error {
+	s.cancel()
+	s.mu.Lock()
+	profiles := []HttpRequestDirector{s.def}
+	seen := map[HttpRequestDirector]bool{s.def: true}
+	for _, m := range []map[string]HttpRequestDirector{s.trackers, s.torrents} {
+		for _, p := range m {
+			if !seen[p] {
+				seen[p] = true
+				profiles = append(profiles, p)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	return NewDirectors(profiles...).Close(ctx)
+}
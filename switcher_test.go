@@ -0,0 +1,247 @@
+package camouflagetorrentclients
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/transmission"
+	"github.com/charleshuang3/camouflagetorrentclients/utorrent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type trackerAnnounce struct {
+	path      string
+	event     string
+	peerID    string
+	userAgent string
+	passkey   string
+}
+
+func TestSwitcher(t *testing.T) {
+	var mu sync.Mutex
+	var received []trackerAnnounce
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		q := r.URL.Query()
+		received = append(received, trackerAnnounce{
+			path:      r.URL.Path,
+			event:     q.Get("event"),
+			peerID:    q.Get("peer_id"),
+			userAgent: r.Header.Get("User-Agent"),
+			passkey:   q.Get("passkey"),
+		})
+	}))
+	defer server.Close()
+	takeReceived := func() []trackerAnnounce {
+		mu.Lock()
+		defer mu.Unlock()
+		res := received
+		received = nil
+		return res
+	}
+
+	tr := transmission.New(transmission.WithoutScrape())
+	ut := utorrent.New()
+	s := NewSwitcher(tr, torrent.NewDefaultClientConfig())
+
+	announce := func(path, infoHash, event string) url.Values {
+		rawQuery := "passkey=abc&compact=1&downloaded=0&" + event + "info_hash=" + infoHash + "&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0"
+		req, err := http.NewRequest("GET", server.URL+path+"?"+rawQuery, nil)
+		require.NoError(t, err)
+		require.NoError(t, s.ChangeHttpRequest(req))
+		return req.URL.Query()
+	}
+
+	q1 := announce("/a/announce", "hash1", "event=started&")
+	announce("/b/announce", "hash1", "event=started&")
+	announce("/a/announce", "hash2", "event=started&")
+	announce("/a/announce", "hash3", "event=started&")
+	announce("/a/announce", "hash3", "event=stopped&")
+
+	// Switch tracker a, hash1 and hash2 are re-announced, stopped hash3 is not.
+	require.NoError(t, s.SwitchTracker(context.Background(), server.URL+"/a/announce", ut))
+	got := takeReceived()
+	require.Len(t, got, 4)
+	for i := 0; i < len(got); i += 2 {
+		stopped, started := got[i], got[i+1]
+		assert.Equal(t, "/a/announce", stopped.path)
+		assert.Equal(t, "stopped", stopped.event)
+		assert.Equal(t, q1.Get("peer_id"), stopped.peerID, "stopped with the old identity")
+		assert.Equal(t, "Transmission/4.0.6", stopped.userAgent)
+		assert.Equal(t, "abc", stopped.passkey)
+
+		assert.Equal(t, "/a/announce", started.path)
+		assert.Equal(t, "started", started.event)
+		assert.Equal(t, "uTorrent/355(45852)", started.userAgent)
+		assert.Equal(t, "abc", started.passkey)
+	}
+
+	// Later announces use the new profile and its identity.
+	q2 := announce("/a/announce", "hash1", "")
+	assert.Equal(t, got[1].peerID, q2.Get("peer_id"))
+	// Tracker b is not switched.
+	q3 := announce("/b/announce", "hash1", "")
+	assert.Equal(t, q1.Get("peer_id"), q3.Get("peer_id"))
+
+	// Switch hash1 back on all trackers, it wins over tracker a.
+	require.NoError(t, s.SwitchTorrent(context.Background(), "hash1", tr))
+	got = takeReceived()
+	require.Len(t, got, 2)
+	assert.Equal(t, trackerAnnounce{path: "/a/announce", event: "stopped", peerID: q2.Get("peer_id"), userAgent: "uTorrent/355(45852)", passkey: "abc"}, got[0])
+	assert.Equal(t, "started", got[1].event)
+	assert.Equal(t, "Transmission/4.0.6", got[1].userAgent)
+
+	// Switch again to the same profile does nothing.
+	require.NoError(t, s.SwitchTorrent(context.Background(), "hash1", tr))
+	assert.Empty(t, takeReceived())
+
+	assert.NoError(t, s.Close(context.Background()))
+}
+
+func TestSwitcher_TrackerDown(t *testing.T) {
+	var down atomic.Bool
+	down.Store(true)
+	var mu sync.Mutex
+	var received []url.Values
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, r.URL.Query())
+	}))
+	defer server.Close()
+
+	ut := utorrent.New()
+	s := NewSwitcher(transmission.New(transmission.WithoutScrape()), torrent.NewDefaultClientConfig())
+	announce := func(event string, uploaded int) *http.Request {
+		req, err := http.NewRequest("GET", server.URL+"/announce?compact=1&downloaded=50&"+event+"info_hash=hash1&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded="+strconv.Itoa(uploaded), nil)
+		require.NoError(t, err)
+		require.NoError(t, s.ChangeHttpRequest(req))
+		return req
+	}
+	announce("event=started&", 100)
+
+	err := s.SwitchTracker(context.Background(), server.URL+"/announce", ut)
+	assert.ErrorContains(t, err, "503")
+
+	// Not stopped, later announces stay on the old profile.
+	req := announce("", 100)
+	assert.Equal(t, "Transmission/4.0.6", req.Header.Get("User-Agent"))
+
+	// Moved by the next switch.
+	down.Store(false)
+	require.NoError(t, s.SwitchTracker(context.Background(), server.URL+"/announce", ut))
+	require.Len(t, received, 2)
+	assert.Equal(t, "stopped", received[0].Get("event"))
+	assert.Equal(t, "100", received[0].Get("uploaded"), "stopped with the old stats")
+	assert.Equal(t, "started", received[1].Get("event"))
+	assert.Equal(t, "0", received[1].Get("uploaded"), "new peer_id starts at 0")
+	assert.Equal(t, "0", received[1].Get("downloaded"))
+
+	req = announce("", 150)
+	assert.Equal(t, "uTorrent/355(45852)", req.Header.Get("User-Agent"))
+	assert.Equal(t, "50", req.URL.Query().Get("uploaded"), "counted from the switch")
+	assert.Equal(t, "0", req.URL.Query().Get("downloaded"))
+}
+
+func TestNewSwitcher_TrackerDialContext(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer server.Close()
+
+	// Switching announces go through the dialer of anacrolix/torrent.
+	cfg := torrent.NewDefaultClientConfig()
+	cfg.TrackerDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+	}
+	s := NewSwitcher(transmission.New(transmission.WithoutScrape()), cfg)
+	defer s.Close(context.Background())
+	req, err := http.NewRequest("GET", "http://tracker.invalid/announce?compact=1&downloaded=0&event=started&info_hash=hash1&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	require.NoError(t, s.ChangeHttpRequest(req))
+
+	require.NoError(t, s.SwitchTracker(context.Background(), "http://tracker.invalid/announce", utorrent.New()))
+	assert.EqualValues(t, 2, received.Load(), "stopped and started")
+}
+
+func TestSwitcher_ExpireIdle(t *testing.T) {
+	var received atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received.Add(1)
+	}))
+	defer server.Close()
+
+	s := NewSwitcher(transmission.New(transmission.WithoutScrape()), torrent.NewDefaultClientConfig())
+	defer s.Close(context.Background())
+	s.SetIdleTTL(time.Millisecond)
+	req, err := http.NewRequest("GET", server.URL+"/announce?compact=1&downloaded=0&event=started&info_hash=hash1&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	require.NoError(t, s.ChangeHttpRequest(req))
+
+	time.Sleep(5 * time.Millisecond)
+	s.expireIdle()
+	s.mu.Lock()
+	assert.Empty(t, s.announces, "dropped without stopped")
+	s.mu.Unlock()
+
+	// Expired torrents are not moved.
+	require.NoError(t, s.SwitchTracker(context.Background(), server.URL+"/announce", utorrent.New()))
+	assert.Zero(t, received.Load())
+}
+
+func TestSwitcher_AnnounceNotBlocked(t *testing.T) {
+	hung := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/hung/") {
+			<-hung
+		}
+	}))
+	defer server.Close()
+	defer close(hung)
+
+	s := NewSwitcher(transmission.New(transmission.WithoutScrape()), torrent.NewDefaultClientConfig())
+	announce := func(path, infoHash string) error {
+		req, err := http.NewRequest("GET", server.URL+path+"?compact=1&downloaded=0&info_hash="+infoHash+"&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+		require.NoError(t, err)
+		return s.ChangeHttpRequest(req)
+	}
+	require.NoError(t, announce("/hung/announce", "hash1"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	switched := make(chan error, 1)
+	go func() {
+		switched <- s.SwitchTracker(ctx, server.URL+"/hung/announce", utorrent.New())
+	}()
+
+	// Other torrents announce while the switch waits for the hung tracker.
+	done := make(chan error, 1)
+	go func() {
+		done <- announce("/other/announce", "hash2")
+	}()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("announce blocked by switching")
+	}
+
+	cancel()
+	assert.ErrorIs(t, <-switched, context.Canceled)
+}
@@ -0,0 +1,73 @@
+package tixati
+
+import (
+	"fmt"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	tixatiV328Bep20 = "TIX0328-"
+)
+
+var (
+	headers = []commons.Header{
+		{Name: "User-Agent", Value: "Tixati 3.28"},
+		{Name: "Accept-Encoding", Value: "gzip"},
+	}
+
+	// tixati announces in the same fixed order and format as Tixati 3.28.
+	//
+	// Tixati is closed source, the order follows what Tixati 3.28 sends on the wire:
+	//
+	// info_hash, peer_id, port, uploaded, downloaded, left, key, event, numwant, compact, no_peer_id, supportcrypto
+	//
+	// Tixati does not want peers when stopping.
+	tixati = &commons.ClientDef{
+		Name: "tixati",
+		Client: commons.ClientIdentity{
+			Bep20:                          tixatiV328Bep20,
+			ExtendedHandshakeClientVersion: "Tixati 3.28",
+			HTTPUserAgent:                  headers[0].Value,
+			UpnpID:                         "Tixati",
+		},
+		Headers: headers,
+		QueryDefs: []*commons.QueryDef{
+			commons.MustHaveDef("info_hash"),
+			commons.MustHaveDef("peer_id"),
+			commons.MustHaveDef("port"),
+			commons.MustHaveDef("uploaded"),
+			commons.MustHaveDef("downloaded"),
+			commons.MustHaveDef("left"),
+			commons.MustHaveDef("key"),
+			commons.OptionalDef("event"),
+			commons.MustHaveDef("numwant"),
+			commons.MustHaveDef("compact"),
+			commons.FixedDef("no_peer_id", "1"),
+			commons.MustHaveDef("supportcrypto"),
+		},
+		NumWant:     commons.NumWant{Announce: 50, Stopped: 0},
+		Scopes:      commons.Scopes{PeerID: commons.ScopeSession, Key: commons.ScopeSession},
+		NewIdentity: createPerTorrent,
+	}
+)
+
+// New creates a director announcing as Tixati 3.28.
+func New() *commons.ClientDirector {
+	s, err := commons.NewClientDirector(tixati)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func createPerTorrent() *commons.Identity {
+	// peer_id is "TIX0328-" + 12 random lower case alphanumeric chars. Per session.
+	// See ConfigureClient.
+
+	// key is random uint32 in 08X format. Per session.
+	return &commons.Identity{
+		PeerID: tixatiV328Bep20 + commons.RandomString(commons.AlphaNumLower, 12),
+		Key:    fmt.Sprintf("%08X", commons.RandomUint32()),
+	}
+}
@@ -0,0 +1,58 @@
+package tixati
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAnnounce(t *testing.T) {
+	rd := New()
+	cfg := torrent.NewDefaultClientConfig()
+	rd.ConfigureClient(cfg)
+	assert.Equal(t, tixatiV328Bep20, cfg.Bep20)
+	assert.Equal(t, "Tixati 3.28", cfg.ExtendedHandshakeClientVersion)
+	assert.Equal(t, "Tixati", cfg.UpnpID)
+
+	testCases := []struct {
+		event   string
+		numwant string
+	}{
+		{event: "started", numwant: "50"},
+		{event: "stopped", numwant: "0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.event, func(t *testing.T) {
+			req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&event="+tc.event+"&info_hash=123&key=1&left=0&peer_id=OLD&port=3456&supportcrypto=1&uploaded=0", nil)
+			require.NoError(t, err)
+			req.Header.Set("X-Custom-Header", "ShouldBeRemoved")
+			require.NoError(t, rd.ChangeHttpRequest(req))
+
+			assert.Equal(t, http.Header{
+				"User-Agent":      {"Tixati 3.28"},
+				"Accept-Encoding": {"gzip"},
+			}, req.Header)
+
+			q, err := commons.QueryParamsFromRawQueryStr(req.URL.RawQuery)
+			require.NoError(t, err)
+			var names []string
+			for _, p := range q {
+				names = append(names, p.Name)
+			}
+			assert.Equal(t, []string{"info_hash", "peer_id", "port", "uploaded", "downloaded", "left", "key", "event", "numwant", "compact", "no_peer_id", "supportcrypto"}, names)
+
+			v := req.URL.Query()
+			assert.Equal(t, tc.numwant, v.Get("numwant"))
+			assert.Equal(t, "1", v.Get("no_peer_id"))
+			assert.True(t, strings.HasPrefix(v.Get("peer_id"), tixatiV328Bep20))
+			assert.Equal(t, cfg.PeerID, v.Get("peer_id"), "peer_id is per session")
+			assert.Regexp(t, `^[0-9A-F]{8}$`, v.Get("key"))
+		})
+	}
+}
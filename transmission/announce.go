@@ -1,59 +1,148 @@
 package transmission
 
 import (
-	"crypto/rand"
+	"context"
 	"fmt"
-	"math/big"
+	"io"
 	"net/http"
-	"net/url"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/anacrolix/log"
+	"github.com/anacrolix/torrent"
 	"github.com/charleshuang3/camouflagetorrentclients/commons"
-	"github.com/madflojo/tasks"
-	"golang.org/x/time/rate"
-)
-
-const (
-	transmissionV406Bep20 = "-TR4060-"
 )
 
 var (
 	logger = log.NewLogger("transmission")
 )
 
-type perTorrent struct {
-	peerID string
-	key    string
-}
-
-// mimickTransmission builds the announce request query parameters in the same fixed order
-// and format as the mimickTransmission BitTorrent client.
+// Transmission builds the announce request query parameters in the same fixed order
+// and format as the Transmission BitTorrent client.
 //
 // transmission 4.0.6:
 //
-// https://github.com/mimickTransmission/mimickTransmission/blob/38c164933e9f77c110b48fe745861c3b98e3d83e/libtransmission/announcer-http.cc#L185
-type mimickTransmission struct {
-	// info_hash -> peer_id, key
-	torrents          sync.Map
-	scheduler         *tasks.Scheduler
-	scrapeRateLimiter *rate.Limiter
+// https://github.com/transmission/transmission/blob/38c164933e9f77c110b48fe745861c3b98e3d83e/libtransmission/announcer-http.cc#L185
+//
+// Other versions see version.go.
+type Transmission struct {
+	version *version
+	client  commons.ClientIdentity
+	// announce url + info_hash -> peer_id, key
+	torrents *commons.Identities
+	rand     io.Reader
+	now      func() time.Time
+
+	// nil if WithoutScrape.
+	scraper *commons.Scraper
+	// idle expiry and Close.
+	upkeep *commons.Upkeep
+
+	mu sync.Mutex
+	// announce url + info_hash -> tracker id, guarded by mu.
+	trackerIDs map[string]string
+	// retry backoff and pacing.
+	pacer *pacer
 }
 
-func New() *mimickTransmission {
-	return &mimickTransmission{
-		torrents:          sync.Map{},
-		scheduler:         tasks.New(),
-		scrapeRateLimiter: rate.NewLimiter(rate.Limit(maxScrapesPerSecond), maxScrapesPerSecond),
+// New mimicks Transmission DefaultVersion. It panics if an Option fails, use
+// NewVersion to handle the error.
+func New(opts ...Option) *Transmission {
+	s, err := NewVersion(DefaultVersion, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// NewVersion mimicks the given Transmission version, e.g. "4.0.6". See
+// SupportedVersions.
+func NewVersion(v string, opts ...Option) (*Transmission, error) {
+	ver, err := lookupVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	create := func() *commons.Identity {
+		return ver.createPerTorrent(o.rand)
+	}
+	// Transmission uses the same peer_id and key for all torrents in a session.
+	scopes := commons.Scopes{PeerID: commons.ScopeSession, Key: commons.ScopeSession}
+	torrents := commons.NewIdentities(scopes, create)
+	if o.store != nil {
+		if torrents, err = commons.NewStoredIdentities(scopes, create, ver.bep20, o.store); err != nil {
+			return nil, err
+		}
 	}
+	torrents.SetClock(o.now)
+	torrents.SetIdleTTL(o.idleTTL)
+
+	client := commons.ClientIdentity{
+		PeerID:                         torrents.Session().PeerID,
+		Bep20:                          ver.bep20,
+		ExtendedHandshakeClientVersion: "Transmission " + v,
+		HTTPUserAgent:                  "Transmission/" + v,
+		UpnpID:                         "Transmission",
+	}
+	s := &Transmission{
+		version:    ver,
+		client:     client,
+		torrents:   torrents,
+		rand:       o.rand,
+		now:        o.now,
+		trackerIDs: map[string]string{},
+		pacer:      newPacer(o),
+	}
+	if o.scrape {
+		s.scraper = commons.NewScraper(o.httpClient, ver.headers, o.scrapeInterval, o.maxScrapesPerSecond)
+		s.scraper.SetClock(o.now)
+		s.scraper.SetRand(o.rand)
+	}
+	s.upkeep = commons.StartUpkeep(logger, torrents, s.scraper, o.idleCheckInterval, s.forget)
+	return s, nil
+}
+
+// Close stops idle expiry and scheduled scrapes, cancels in-flight scrapes and
+// persists the identities to the store. It returns when in-flight scrapes
+// returned, or ctx.Err() if ctx is done first. Announces are still rewritten
+// after Close, without scrapes.
+func (s *Transmission) Close(ctx context.Context) error {
+	return s.upkeep.Close(ctx)
+}
+
+// SetIdleTTL changes how long a torrent on a tracker is kept without announces,
+// commons.DefaultIdleTTL by default. 0 keeps it until stopped.
+func (s *Transmission) SetIdleTTL(ttl time.Duration) {
+	s.torrents.SetIdleTTL(ttl)
 }
 
-func (s *mimickTransmission) ChangeHttpRequest(r *http.Request) error {
+// Evicted returns the number of torrents on trackers removed for idle, which
+// were dropped by anacrolix/torrent without announcing stopped.
+func (s *Transmission) Evicted() int64 {
+	return s.torrents.Evicted()
+}
+
+// forget removes the tracker id and pacing of an idle torrent on a tracker,
+// the upkeep removes its peer_id, key and scrape task.
+func (s *Transmission) forget(id string) {
+	s.setTrackerID(id, "")
+	s.pacer.delete(id)
+}
+
+// ConfigureClient applies the Transmission identity to the client, so BitTorrent
+// handshakes use the same peer_id as announces.
+func (s *Transmission) ConfigureClient(cfg *torrent.ClientConfig) {
+	s.client.Apply(cfg)
+}
+
+func (s *Transmission) ChangeHttpRequest(r *http.Request) error {
 	// Do nothing for scrape request. anacrolix/torrent does not call HttpRequestDirector right now.
 	// Just incase the behavior changed.
-	parts := strings.Split(r.URL.Path, "/")
-	if parts[len(parts)-1] == "scrape" {
+	if commons.IsScrape(r.URL) {
 		return nil
 	}
 
@@ -61,19 +150,55 @@ func (s *mimickTransmission) ChangeHttpRequest(r *http.Request) error {
 	if err != nil {
 		return err
 	}
-	return modifyHeaders(r)
+	return s.modifyHeaders(r)
+}
+
+// ObserveAnnounce records the tracker id the tracker responds, Transmission
+// sends it back as trackerid in all later announces of the torrent to the
+// tracker, until stopped. It records the failures and min interval as well, see
+// WithRetryIntervals and WithAnnouncePacing.
+//
+// Responses are handed over by camouflagetorrentclients.Install, only for plain
+// HTTP trackers, and by Switcher.
+//
+// https://github.com/transmission/transmission/blob/4.0.6/libtransmission/announcer.cc
+func (s *Transmission) ObserveAnnounce(req *http.Request, resp *commons.AnnounceResponse, err error) {
+	q := req.URL.Query()
+	if q.Get("event") == commons.EventStopped {
+		return
+	}
+	id := commons.PerTrackerTorrentID(req.URL, q.Get("info_hash"))
+	if err == nil && resp.TrackerID != "" {
+		s.setTrackerID(id, resp.TrackerID)
+	}
+	s.pacer.observe(id, resp, err)
 }
 
-func (s *mimickTransmission) modifyQuery(r *http.Request) error {
-	q := r.URL.Query()
+// setTrackerID records the tracker id of the torrent on the tracker, removes it
+// if empty.
+func (s *Transmission) setTrackerID(id, trackerID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	// RawQuery may contains private tracker's query at the beginning.
-	// before "&compact"
-	index := strings.Index(r.URL.RawQuery, "&compact")
-	privateTrackerQuery := ""
-	if index != -1 {
-		privateTrackerQuery = r.URL.RawQuery[0:index]
+	if trackerID == "" {
+		delete(s.trackerIDs, id)
+	} else {
+		s.trackerIDs[id] = trackerID
 	}
+}
+
+// trackerID returns the tracker id of the torrent on the tracker, empty if the
+// tracker did not give one.
+func (s *Transmission) trackerID(id string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.trackerIDs[id]
+}
+
+func (s *Transmission) modifyQuery(r *http.Request) error {
+	// RawQuery may contains private tracker's own query, like passkey.
+	trackerQuery, q := commons.SplitAnnounceQuery(r.URL.RawQuery)
 
 	// transmission use fixed value for "numwant", "compact", "supportcrypto".
 	// anacrolix/torrent does not provide "numwant", and assign fixed value for "compact", "supportcrypto".
@@ -96,8 +221,15 @@ func (s *mimickTransmission) modifyQuery(r *http.Request) error {
 	}
 	event := q.Get("event")
 
-	id := perTrackerTorrentID(r.URL, infoHash)
-	got, exists := s.torrents.LoadOrStore(id, createPerTorrent())
+	id := commons.PerTrackerTorrentID(r.URL, infoHash)
+	if err := s.pacer.wait(r.Context(), id, event); err != nil {
+		return err
+	}
+	pt, exists := s.torrents.LoadOrCreate(id, infoHash)
+	// Sent in the stopped announce as well.
+	if trackerID := s.trackerID(id); trackerID != "" {
+		q.Set("trackerid", trackerID)
+	}
 	if event == commons.EventStarted {
 		// It is a bug if exists.
 		if exists {
@@ -105,106 +237,42 @@ func (s *mimickTransmission) modifyQuery(r *http.Request) error {
 		}
 	} else if event == commons.EventStopped {
 		s.torrents.Delete(id)
-		s.scheduler.Del(id)
+		s.forget(id)
+		s.scraper.Remove(id)
 	}
 	// Announce not following a started event is possible, when seeding a finished torrent.
 
 	// schedule scrape requests.
-	if !exists {
-		s.scheduleScrape(id, newScrapeTask(s, r.URL, infoHash, privateTrackerQuery))
+	if !exists && event != commons.EventStopped {
+		s.scraper.Add(id, r.URL, infoHash, trackerQuery)
 	}
 
-	pt := got.(*perTorrent)
-
-	q.Set("peer_id", pt.peerID)
-	q.Set("key", pt.key)
-
-	queryDefs := []*commons.QueryDef{
-		commons.MustHaveDef("info_hash"),
-		commons.MustHaveDef("peer_id"),
-		commons.MustHaveDef("port"),
-		commons.MustHaveDef("uploaded"),
-		commons.MustHaveDef("downloaded"),
-		commons.MustHaveDef("left"),
-		commons.MustHaveDef("numwant"),
-		commons.MustHaveDef("key"),
-		commons.MustHaveDef("compact"),
-		commons.MustHaveDef("supportcrypto"),
-		commons.OptionalDef("requirecrypto"),
-		commons.OptionalDef("event"),
-		commons.OptionalDef("corrupt"),
-		commons.OptionalDef("trackerid"),
-	}
+	q.Set("peer_id", pt.PeerID)
+	q.Set("key", pt.Key)
 
-	params, err := commons.ProcessQuery(queryDefs, q)
+	params, err := commons.ProcessQuery(s.version.queryDefs, q)
 	if err != nil {
 		return err
 	}
 
-	if privateTrackerQuery != "" {
-		r.URL.RawQuery = privateTrackerQuery + "&" + params.Str()
-	} else {
-		r.URL.RawQuery = params.Str()
-	}
+	r.URL.RawQuery = trackerQuery.Join(params.Str())
 
 	return nil
 }
 
-func modifyHeaders(r *http.Request) error {
-	// Clear existing headers
-	for k := range r.Header {
-		delete(r.Header, k)
-	}
-
-	// Add new headers
-	r.Header.Set("Accept-Encoding", "deflate, gzip, br, zstd")
-	r.Header.Set("User-Agent", "Transmission/4.0.6")
-	r.Header.Set("Accept", "*/*")
-
+func (s *Transmission) modifyHeaders(r *http.Request) error {
+	commons.ReplaceHeaders(r, s.version.headers)
 	return nil
 }
 
-func createPerTorrent() *perTorrent {
+func (v *version) createPerTorrent(r io.Reader) *commons.Identity {
 	// https://github.com/transmission/transmission/blob/ac5c9e082da257e102eb4ff18f2e433976a585d1/libtransmission/session.cc#L194
-	// peer_id should be "-TRxyzb-" + 12 random alphanumeric char. Per session.
-	// But anacrolix/torrent is per client.
-	charSet := "0123456789abcdefghijklmnopqrstuvwxyz"
-
-	// On transimission, key is random uint32 in 08X format. Per session.
-	// But anacrolix/torrent is per client.
-
-	// Generate peer_id
-	peerID := make([]byte, 12)
-	for i := range peerID {
-		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(charSet))))
-		if err != nil {
-			// crypto/rand should not fail on Linux/macOS. Panic if it does.
-			panic(fmt.Errorf("failed to generate random int for peer ID: %w", err))
-		}
-		peerID[i] = charSet[n.Int64()]
-	}
+	// peer_id should be "-TRxyzb-" + 12 random alphanumeric char with checksum,
+	// see newPeerID. Per session, see ConfigureClient.
 
-	// Generate key
-	keyBytes := make([]byte, 4) // 4 bytes for uint32
-	_, err := rand.Read(keyBytes)
-	if err != nil {
-		// crypto/rand should not fail on Linux/macOS. Panic if it does.
-		panic(fmt.Errorf("failed to generate random bytes for key: %w", err))
-	}
-	key := fmt.Sprintf("%08X", keyBytes) // Format as 8-char uppercase hex
-
-	return &perTorrent{
-		peerID: transmissionV406Bep20 + string(peerID),
-		key:    key,
+	// On transimission, key is random uint32 in 08X format (x on 3.00). Per session.
+	return &commons.Identity{
+		PeerID: newPeerID(r, v.bep20),
+		Key:    fmt.Sprintf(v.keyFormat, commons.RandomUint32From(r)),
 	}
 }
-
-func announceURL(u *url.URL) string {
-	urlCopy := *u
-	urlCopy.RawQuery = ""
-	return urlCopy.String()
-}
-
-func perTrackerTorrentID(u *url.URL, infoHash string) string {
-	return announceURL(u) + "--" + infoHash
-}
@@ -2,6 +2,8 @@ package transmission
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -160,31 +162,32 @@ func TestCreatePerTorrent(t *testing.T) {
 	previousKeys := make(map[string]bool)
 
 	for i := 0; i < runs; i++ {
-		pt := createPerTorrent()
+		pt := versions[DefaultVersion].createPerTorrent(rand.Reader)
 		require.NotNil(t, pt, "createPerTorrent returned nil on run %d", i+1)
 
 		// Peer ID checks
-		assert.Len(t, pt.peerID, 20, "Peer ID length mismatch on run %d", i+1)
-		assert.True(t, strings.HasPrefix(pt.peerID, transmissionV406Bep20), "Peer ID prefix mismatch on run %d", i+1)
-		randomPartPeerID := pt.peerID[len(transmissionV406Bep20):]
+		assert.Len(t, pt.PeerID, 20, "Peer ID length mismatch on run %d", i+1)
+		assert.True(t, strings.HasPrefix(pt.PeerID, transmissionV406Bep20), "Peer ID prefix mismatch on run %d", i+1)
+		randomPartPeerID := pt.PeerID[len(transmissionV406Bep20):]
 		assert.Len(t, randomPartPeerID, 12, "Peer ID random part length mismatch on run %d", i+1)
 		for _, char := range randomPartPeerID {
 			assert.True(t, (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9'),
 				"Peer ID random part contains invalid character '%c' on run %d", char, i+1)
 		}
+		assert.NoError(t, ValidatePeerID(pt.PeerID), "Peer ID checksum mismatch on run %d", i+1)
 
 		// Key checks
-		assert.Len(t, pt.key, 8, "Key length mismatch on run %d", i+1)
-		for _, char := range pt.key {
+		assert.Len(t, pt.Key, 8, "Key length mismatch on run %d", i+1)
+		for _, char := range pt.Key {
 			assert.True(t, (char >= '0' && char <= '9') || (char >= 'A' && char <= 'F'),
 				"Key contains invalid character '%c' on run %d", char, i+1)
 		}
 
 		// Check for uniqueness (highly likely)
-		assert.False(t, previousPeerIDs[pt.peerID], "Duplicate Peer ID generated: %s", pt.peerID)
-		assert.False(t, previousKeys[pt.key], "Duplicate Key generated: %s", pt.key)
-		previousPeerIDs[pt.peerID] = true
-		previousKeys[pt.key] = true
+		assert.False(t, previousPeerIDs[pt.PeerID], "Duplicate Peer ID generated: %s", pt.PeerID)
+		assert.False(t, previousKeys[pt.Key], "Duplicate Key generated: %s", pt.Key)
+		previousPeerIDs[pt.PeerID] = true
+		previousKeys[pt.Key] = true
 	}
 }
 
@@ -342,14 +345,12 @@ func TestHttpRequestDirector_PerTorrentHandling(t *testing.T) {
 
 	// Check stored data after first call
 	id1 := announce + "--" + infoHashUnescaped
-	storedData, ok := tr.torrents.Load(id1)
+	pt, ok := tr.torrents.Load(id1)
 	require.True(t, ok, "PerTorrent data not found in map after first call")
-	pt, ok := storedData.(*perTorrent)
-	require.True(t, ok, "Stored data is not of type *perTorrent")
-	assert.Equal(t, generatedPeerID, pt.peerID, "Stored peerID does not match generated peerID")
-	assert.Equal(t, generatedKey, pt.key, "Stored key does not match generated key")
+	assert.Equal(t, generatedPeerID, pt.PeerID, "Stored peerID does not match generated peerID")
+	assert.Equal(t, generatedKey, pt.Key, "Stored key does not match generated key")
 
-	_, task1Exists := tr.scheduler.Tasks()[id1]
+	task1Exists := tr.scraper.Scheduled(id1)
 	assert.True(t, task1Exists, "scrape task scheduled")
 
 	// --- Subsequent call (event=started or no event) - should reuse ---
@@ -368,14 +369,12 @@ func TestHttpRequestDirector_PerTorrentHandling(t *testing.T) {
 	assert.Equal(t, generatedKey, q2.Get("key"), "key should be reused on second call")
 
 	// Verify data still exists and is unchanged
-	storedData2, ok := tr.torrents.Load(id1)
+	pt2, ok := tr.torrents.Load(id1)
 	require.True(t, ok, "PerTorrent data not found in map after second call")
-	pt2, ok := storedData2.(*perTorrent)
-	require.True(t, ok, "Stored data is not of type *perTorrent after second call")
-	assert.Equal(t, generatedPeerID, pt2.peerID, "Stored peerID should not change after second call")
-	assert.Equal(t, generatedKey, pt2.key, "Stored key should not change after second call")
+	assert.Equal(t, generatedPeerID, pt2.PeerID, "Stored peerID should not change after second call")
+	assert.Equal(t, generatedKey, pt2.Key, "Stored key should not change after second call")
 
-	_, task1Exists = tr.scheduler.Tasks()[id1]
+	task1Exists = tr.scraper.Scheduled(id1)
 	assert.True(t, task1Exists, "scrape task still scheduled")
 
 	// --- Call with 'stopped' event - should remove data ---
@@ -393,7 +392,7 @@ func TestHttpRequestDirector_PerTorrentHandling(t *testing.T) {
 	_, ok = tr.torrents.Load(id1)
 	assert.False(t, ok, "PerTorrent data should be removed after 'stopped' event")
 
-	_, task1Exists = tr.scheduler.Tasks()[id1]
+	task1Exists = tr.scraper.Scheduled(id1)
 	assert.False(t, task1Exists, "scrape task stopped")
 
 	// --- Call after 'stopped' - should generate new data ---
@@ -408,18 +407,16 @@ func TestHttpRequestDirector_PerTorrentHandling(t *testing.T) {
 	newGeneratedKey := q4.Get("key")
 	require.NotEmpty(t, generatedKey)
 
-	assert.NotEqual(t, generatedPeerID, newGeneratedPeerID, "New peer_id should be generated after stopped event")
-	assert.NotEqual(t, generatedKey, newGeneratedKey, "New key should be generated after stopped event")
+	assert.Equal(t, generatedPeerID, newGeneratedPeerID, "peer_id is per session")
+	assert.Equal(t, generatedKey, newGeneratedKey, "key is per session")
 
 	// Check stored data after fourth call
-	storedData4, ok := tr.torrents.Load(id1)
+	pt4, ok := tr.torrents.Load(id1)
 	require.True(t, ok, "PerTorrent data not found in map after fourth call")
-	pt4, ok := storedData4.(*perTorrent)
-	require.True(t, ok, "Stored data is not of type *perTorrent after fourth call")
-	assert.Equal(t, newGeneratedPeerID, pt4.peerID, "Stored peerID does not match newly generated peerID")
-	assert.Equal(t, newGeneratedKey, pt4.key, "Stored key does not match newly generated key")
+	assert.Equal(t, newGeneratedPeerID, pt4.PeerID, "Stored peerID does not match newly generated peerID")
+	assert.Equal(t, newGeneratedKey, pt4.Key, "Stored key does not match newly generated key")
 
-	_, task1Exists = tr.scheduler.Tasks()[id1]
+	task1Exists = tr.scraper.Scheduled(id1)
 	assert.True(t, task1Exists, "new scrape task scheduled")
 
 	// --- Call with different tracker, same infohash ---
@@ -436,26 +433,240 @@ func TestHttpRequestDirector_PerTorrentHandling(t *testing.T) {
 	tracker2Key := q5.Get("key")
 	require.NotEmpty(t, tracker2Key)
 
-	// Verify new peer_id and key are different from the ones for the first tracker
-	assert.NotEqual(t, newGeneratedPeerID, tracker2PeerID, "PeerID should be different for different tracker")
-	assert.NotEqual(t, newGeneratedKey, tracker2Key, "Key should be different for different tracker")
+	// Verify the second tracker sees the same peer_id and key
+	assert.Equal(t, newGeneratedPeerID, tracker2PeerID, "PeerID is per session")
+	assert.Equal(t, newGeneratedKey, tracker2Key, "key is per session")
 
 	// Verify a new entry exists for the new tracker/infohash combo
 	id2 := announce2 + "--" + infoHashUnescaped
-	storedData5, ok := tr.torrents.Load(id2)
+	pt5, ok := tr.torrents.Load(id2)
 	require.True(t, ok, "PerTorrent data not found in map for second tracker")
-	pt5, ok := storedData5.(*perTorrent)
-	require.True(t, ok, "Stored data is not of type *perTorrent for second tracker")
-	assert.Equal(t, tracker2PeerID, pt5.peerID, "Stored peerID does not match generated peerID for second tracker")
-	assert.Equal(t, tracker2Key, pt5.key, "Stored key does not match generated key for second tracker")
+	assert.Equal(t, tracker2PeerID, pt5.PeerID, "Stored peerID does not match generated peerID for second tracker")
+	assert.Equal(t, tracker2Key, pt5.Key, "Stored key does not match generated key for second tracker")
 
-	_, task2Exists := tr.scheduler.Tasks()[id2]
+	task2Exists := tr.scraper.Scheduled(id2)
 	assert.True(t, task2Exists, "new scrape task scheduled")
 
 	// Verify the entry for the first tracker still exists (from req4)
 	_, ok = tr.torrents.Load(id1)
 	assert.True(t, ok, "PerTorrent data for the first tracker should still exist")
 
-	_, task1Exists = tr.scheduler.Tasks()[id1]
+	task1Exists = tr.scraper.Scheduled(id1)
 	assert.True(t, task1Exists, "scrape task still scheduled")
 }
+
+func TestConfigureClient(t *testing.T) {
+	tr, err := NewVersion("4.1.0")
+	require.NoError(t, err)
+	cfg := torrent.NewDefaultClientConfig()
+	tr.ConfigureClient(cfg)
+
+	assert.Len(t, cfg.PeerID, 20)
+	assert.True(t, strings.HasPrefix(cfg.PeerID, transmissionV410Bep20))
+	assert.Equal(t, transmissionV410Bep20, cfg.Bep20)
+	assert.Equal(t, "Transmission 4.1.0", cfg.ExtendedHandshakeClientVersion)
+	assert.Equal(t, "Transmission/4.1.0", cfg.HTTPUserAgent)
+	assert.Equal(t, "Transmission", cfg.UpnpID)
+
+	// Trackers of the same torrent see consistent values.
+	peerIDs := []string{}
+	keys := []string{}
+	for _, announce := range []string{"http://example.com/announce", "http://another-tracker.com/announce"} {
+		req, err := http.NewRequest("GET", announce+"?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+		require.NoError(t, err)
+		require.NoError(t, tr.ChangeHttpRequest(req))
+		peerIDs = append(peerIDs, req.URL.Query().Get("peer_id"))
+		keys = append(keys, req.URL.Query().Get("key"))
+	}
+	assert.Equal(t, peerIDs[0], peerIDs[1], "peer_id is shared by trackers")
+	assert.Equal(t, keys[0], keys[1], "key is shared by trackers")
+	assert.Equal(t, cfg.PeerID, peerIDs[0], "handshakes use the announce peer_id")
+}
+
+func TestWithStore(t *testing.T) {
+	store := commons.NewMemoryStore()
+	announce := func(tr *Transmission, event string) url.Values {
+		req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&"+event+"info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+		require.NoError(t, err)
+		require.NoError(t, tr.ChangeHttpRequest(req))
+		return req.URL.Query()
+	}
+
+	tr, err := NewVersion(DefaultVersion, WithStore(store))
+	require.NoError(t, err)
+	q1 := announce(tr, "event=started&")
+
+	// Restart without stopped.
+	tr, err = NewVersion(DefaultVersion, WithStore(store))
+	require.NoError(t, err)
+	cfg := torrent.NewDefaultClientConfig()
+	tr.ConfigureClient(cfg)
+	assert.Equal(t, q1.Get("peer_id"), cfg.PeerID, "session peer_id is restored")
+
+	q2 := announce(tr, "")
+	assert.Equal(t, q1.Get("peer_id"), q2.Get("peer_id"))
+	assert.Equal(t, q1.Get("key"), q2.Get("key"))
+	id := commons.PerTrackerTorrentID(&url.URL{Scheme: "http", Host: "example.com", Path: "/announce"}, "123")
+	taskExists := tr.scraper.Scheduled(id)
+	assert.True(t, taskExists, "scrape task scheduled for restored torrent")
+
+	announce(tr, "event=stopped&")
+	_, trackers, err := store.Load()
+	require.NoError(t, err)
+	assert.Empty(t, trackers, "stopped expires the stored identity")
+	taskExists = tr.scraper.Scheduled(id)
+	assert.False(t, taskExists)
+}
+
+func TestWithStore_OtherVersion(t *testing.T) {
+	store := commons.NewMemoryStore()
+	announce := func(tr *Transmission) string {
+		req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+		require.NoError(t, err)
+		require.NoError(t, tr.ChangeHttpRequest(req))
+		return req.URL.Query().Get("peer_id")
+	}
+
+	tr, err := NewVersion("4.0.6", WithStore(store))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(announce(tr), "-TR4060-"))
+
+	// Upgraded, the 4.0.6 identities are dropped.
+	tr, err = NewVersion("4.1.0", WithStore(store))
+	require.NoError(t, err)
+	cfg := torrent.NewDefaultClientConfig()
+	tr.ConfigureClient(cfg)
+	peerID := announce(tr)
+	assert.True(t, strings.HasPrefix(peerID, "-TR4100-"), peerID)
+	assert.Equal(t, cfg.PeerID, peerID)
+	assert.Equal(t, "-TR4100-", cfg.Bep20)
+}
+
+func TestExpireIdle(t *testing.T) {
+	tr := New()
+	tr.SetIdleTTL(time.Millisecond)
+	req, err := http.NewRequest("GET", "http://example.com/announce?compact=1&downloaded=0&event=started&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	require.NoError(t, tr.ChangeHttpRequest(req))
+	id := commons.PerTrackerTorrentID(req.URL, "123")
+	taskExists := tr.scraper.Scheduled(id)
+	require.True(t, taskExists)
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{TrackerID: "abc"}, nil)
+
+	time.Sleep(5 * time.Millisecond)
+	tr.upkeep.ExpireIdle()
+	assert.Empty(t, tr.trackerID(id), "idle tracker id is removed")
+
+	_, ok := tr.torrents.Load(id)
+	assert.False(t, ok, "idle identity is removed")
+	taskExists = tr.scraper.Scheduled(id)
+	assert.False(t, taskExists, "idle scrape task is removed")
+	assert.EqualValues(t, 1, tr.Evicted())
+}
+
+func TestExpireIdle_WithoutScrape(t *testing.T) {
+	checkOften := func(o *options) {
+		o.idleCheckInterval = time.Millisecond
+	}
+	tr := New(WithoutScrape(), WithIdleTTL(5*time.Millisecond), WithAnnouncePacing(time.Second), checkOften)
+	defer tr.Close(context.Background())
+
+	req, _ := announceTo(t, tr, "http://example.com/announce")
+	id := commons.PerTrackerTorrentID(req.URL, "123")
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{TrackerID: "abc"}, nil)
+
+	paced := func() int {
+		tr.pacer.mu.Lock()
+		defer tr.pacer.mu.Unlock()
+		return len(tr.pacer.torrents)
+	}
+	assert.Eventually(t, func() bool {
+		// The tracker id and pacing are removed after the identity.
+		return tr.trackerID(id) == "" && paced() == 0
+	}, 2*time.Second, time.Millisecond)
+	_, ok := tr.torrents.Load(id)
+	assert.False(t, ok, "idle identity is removed")
+	assert.EqualValues(t, 1, tr.Evicted())
+}
+
+func TestHttpRequestDirector_TrackerQuery(t *testing.T) {
+	announceQuery := "compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0"
+
+	testCases := []struct {
+		name         string
+		url          string
+		wantPath     string
+		wantRawQuery string
+		wantSuffix   string
+	}{
+		{
+			name:         "passkey in path",
+			url:          "http://example.com/abcdef0123/announce?" + announceQuery,
+			wantPath:     "/abcdef0123/announce",
+			wantRawQuery: "info_hash=123&peer_id=",
+		},
+		{
+			name:         "passkey in query",
+			url:          "http://example.com/announce?passkey=compact&" + announceQuery,
+			wantPath:     "/announce",
+			wantRawQuery: "passkey=compact&info_hash=123&peer_id=",
+		},
+		{
+			name:         "passkey in path and query",
+			url:          "http://example.com/abcdef0123/announce?uid=1%2B2&" + announceQuery + "&sig=x",
+			wantPath:     "/abcdef0123/announce",
+			wantRawQuery: "uid=1%2B2&info_hash=123&peer_id=",
+			wantSuffix:   "&sig=x",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tr := New(WithoutScrape())
+			req, err := http.NewRequest("GET", tc.url, nil)
+			require.NoError(t, err)
+			require.NoError(t, tr.ChangeHttpRequest(req))
+
+			assert.Equal(t, tc.wantPath, req.URL.Path)
+			assert.True(t, strings.HasPrefix(req.URL.RawQuery, tc.wantRawQuery), req.URL.RawQuery)
+			assert.True(t, strings.HasSuffix(req.URL.RawQuery, tc.wantSuffix), req.URL.RawQuery)
+		})
+	}
+}
+
+func TestObserveAnnounce_TrackerID(t *testing.T) {
+	// Failures do not hold the announces back.
+	tr := New(WithoutScrape(), WithRetryIntervals())
+	announce := func(announce, event string) *http.Request {
+		req, err := http.NewRequest("GET", announce+testAnnounceQuery+event, nil)
+		require.NoError(t, err)
+		require.NoError(t, tr.ChangeHttpRequest(req))
+		return req
+	}
+	const trackerA = "http://a.example.com/announce"
+	const trackerB = "http://b.example.com/announce"
+
+	req := announce(trackerA, "&event=started")
+	assert.False(t, req.URL.Query().Has("trackerid"))
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{Interval: 1800, TrackerID: "abc"}, nil)
+
+	// Sent back to the same tracker only, as the last param.
+	req = announce(trackerA, "")
+	assert.Equal(t, "abc", req.URL.Query().Get("trackerid"))
+	assert.True(t, strings.HasSuffix(req.URL.RawQuery, "&trackerid=abc"))
+	assert.False(t, announce(trackerB, "&event=started").URL.Query().Has("trackerid"))
+
+	// Kept if later responses have no tracker id, or failed.
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{Interval: 1800}, nil)
+	tr.ObserveAnnounce(req, nil, errors.New("timeout"))
+	assert.Equal(t, "abc", announce(trackerA, "").URL.Query().Get("trackerid"))
+
+	// Replaced by a new one.
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{TrackerID: "def"}, nil)
+	req = announce(trackerA, "&event=stopped")
+	assert.Equal(t, "def", req.URL.Query().Get("trackerid"), "sent in stopped")
+
+	// Cleared on stopped, the response of stopped is ignored.
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{TrackerID: "ghi"}, nil)
+	assert.False(t, announce(trackerA, "&event=started").URL.Query().Has("trackerid"))
+}
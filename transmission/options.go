@@ -0,0 +1,140 @@
+package transmission
+
+import (
+	"crypto/rand"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+// Option configures Transmission.
+type Option func(*options)
+
+type options struct {
+	httpClient          *http.Client
+	scrape              bool
+	scrapeInterval      time.Duration
+	maxScrapesPerSecond int
+	now                 func() time.Time
+	rand                io.Reader
+	store               commons.IdentityStore
+	idleTTL             time.Duration
+	idleCheckInterval   time.Duration
+	pacing              bool
+	maxAnnounceDelay    time.Duration
+	defaultMinInterval  time.Duration
+	retryIntervals      []RetryInterval
+}
+
+func defaultOptions() *options {
+	return &options{
+		httpClient:          http.DefaultClient,
+		scrape:              true,
+		scrapeInterval:      scrapeInterval,
+		maxScrapesPerSecond: maxScrapesPerSecond,
+		now:                 time.Now,
+		rand:                rand.Reader,
+		idleTTL:             commons.DefaultIdleTTL,
+		idleCheckInterval:   commons.IdleCheckInterval,
+		defaultMinInterval:  defaultAnnounceMinInterval,
+		retryIntervals:      DefaultRetryIntervals,
+	}
+}
+
+// WithHTTPClient sends scrape requests with c, http.DefaultClient by default.
+func WithHTTPClient(c *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = c
+	}
+}
+
+// WithScrapeInterval scrapes every d, 30 min by default like Transmission.
+func WithScrapeInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.scrapeInterval = d
+	}
+}
+
+// WithMaxScrapesPerSecond limits scrape requests, 40 per second by default.
+func WithMaxScrapesPerSecond(n int) Option {
+	return func(o *options) {
+		o.maxScrapesPerSecond = n
+	}
+}
+
+// WithoutScrape never sends scrape requests. Idle torrents are still expired.
+func WithoutScrape() Option {
+	return func(o *options) {
+		o.scrape = false
+	}
+}
+
+// WithClock replaces time.Now.
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+// WithRand replaces crypto/rand as the random source of peer_id, key and
+// scrape delays.
+func WithRand(r io.Reader) Option {
+	return func(o *options) {
+		o.rand = r
+	}
+}
+
+// WithStore restores peer_id and key from store, so a restarted client
+// announces as before. Identities are in memory only by default.
+func WithStore(store commons.IdentityStore) Option {
+	return func(o *options) {
+		o.store = store
+	}
+}
+
+// WithIdleTTL forgets a torrent on a tracker not announced for ttl,
+// commons.DefaultIdleTTL by default. 0 keeps it until stopped.
+func WithIdleTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.idleTTL = ttl
+	}
+}
+
+// WithAnnouncePacing holds regular announces back until the tracker's min
+// interval since the last announce passed, 2 min if the tracker does not give
+// one, like Transmission. Events are never held back. Announces to hold back
+// longer than maxDelay, retries as well, fail with ErrTooSoon. Off by default.
+//
+// Min intervals are read from responses, see ObserveAnnounce. Responses are
+// observed with camouflagetorrentclients.Install, announces to trackers whose
+// responses are not seen, e.g. HTTPS, are paced by the default min interval.
+func WithAnnouncePacing(maxDelay time.Duration) Option {
+	return func(o *options) {
+		o.pacing = true
+		o.maxAnnounceDelay = maxDelay
+	}
+}
+
+// WithDefaultMinInterval is the min interval of trackers not giving one, 2 min
+// by default like Transmission. Used with WithAnnouncePacing.
+func WithDefaultMinInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.defaultMinInterval = d
+	}
+}
+
+// WithRetryIntervals replaces DefaultRetryIntervals, intervals[i] is the wait
+// after i+1 consecutive failures, the last one repeats. No intervals retry
+// right away.
+//
+// Regular announces to a failed tracker are held back until the retry interval
+// passed, with or without WithAnnouncePacing. They fail with ErrTooSoon, unless
+// within the max delay of WithAnnouncePacing. Failures are read from observed
+// responses, see WithAnnouncePacing.
+func WithRetryIntervals(intervals ...RetryInterval) Option {
+	return func(o *options) {
+		o.retryIntervals = intervals
+	}
+}
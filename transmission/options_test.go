@@ -0,0 +1,98 @@
+package transmission
+
+import (
+	"context"
+	mathrand "math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAnnounceQuery = "?compact=1&downloaded=0&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0"
+
+func announceTo(t *testing.T, tr *Transmission, announce string) (*http.Request, url.Values) {
+	req, err := http.NewRequest("GET", announce+testAnnounceQuery, nil)
+	require.NoError(t, err)
+	require.NoError(t, tr.ChangeHttpRequest(req))
+	return req, req.URL.Query()
+}
+
+func TestWithRand(t *testing.T) {
+	newRand := func() *mathrand.ChaCha8 {
+		return mathrand.NewChaCha8([32]byte{1, 2, 3})
+	}
+	tr1 := New(WithRand(newRand()), WithoutScrape())
+	tr2 := New(WithRand(newRand()), WithoutScrape())
+
+	_, q1 := announceTo(t, tr1, "http://example.com/announce")
+	_, q2 := announceTo(t, tr2, "http://example.com/announce")
+	assert.Equal(t, q1.Get("peer_id"), q2.Get("peer_id"))
+	assert.Equal(t, q1.Get("key"), q2.Get("key"))
+	assert.NoError(t, ValidatePeerID(q1.Get("peer_id")))
+}
+
+func TestWithoutScrape(t *testing.T) {
+	tr := New(WithoutScrape())
+	assert.Nil(t, tr.scraper)
+
+	req, _ := announceTo(t, tr, "http://example.com/announce")
+	id := commons.PerTrackerTorrentID(req.URL, "123")
+	_, ok := tr.torrents.Load(id)
+	assert.True(t, ok)
+
+	req, err := http.NewRequest("GET", "http://example.com/announce"+testAnnounceQuery+"&event=stopped", nil)
+	require.NoError(t, err)
+	require.NoError(t, tr.ChangeHttpRequest(req))
+	_, ok = tr.torrents.Load(id)
+	assert.False(t, ok)
+}
+
+func TestWithHTTPClient(t *testing.T) {
+	requestReceived := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/scrape", r.URL.Path)
+		select {
+		case requestReceived <- struct{}{}:
+		default:
+		}
+	}))
+	defer server.Close()
+
+	// The first scrape is 1-10s after the announce plus the interval, move the
+	// clock back to scrape right away.
+	past := func() time.Time { return time.Now().Add(-time.Minute) }
+	tr := New(WithHTTPClient(server.Client()), WithScrapeInterval(10*time.Millisecond), WithMaxScrapesPerSecond(1), WithClock(past))
+	defer tr.Close(context.Background())
+	announceTo(t, tr, server.URL+"/announce")
+
+	select {
+	case <-requestReceived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the mock server to receive the scrape request")
+	}
+}
+
+func TestWithClock(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tr := New(WithClock(func() time.Time { return now }), WithIdleTTL(time.Hour))
+
+	req, _ := announceTo(t, tr, "http://example.com/announce")
+	id := commons.PerTrackerTorrentID(req.URL, "123")
+
+	now = now.Add(59 * time.Minute)
+	tr.upkeep.ExpireIdle()
+	_, ok := tr.torrents.Load(id)
+	assert.True(t, ok)
+
+	now = now.Add(2 * time.Minute)
+	tr.upkeep.ExpireIdle()
+	_, ok = tr.torrents.Load(id)
+	assert.False(t, ok)
+	assert.EqualValues(t, 1, tr.Evicted())
+}
@@ -0,0 +1,202 @@
+package transmission
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+// Summary of Transmission Announce Timing:
+//
+//   - Events (started, stopped, completed) are announced right away.
+//   - Regular announces are sent when the tracker's interval passed, 10 min if
+//     the tracker does not give one.
+//   - A torrent can be re-announced earlier on request, but never before the
+//     tracker's min interval since the last announce, 2 min if the tracker does
+//     not give one.
+//   - If the tracker fails, responds a failure reason or times out, the announce
+//     is retried after 20s, then 5, 15, 30 and 60 min, then every 120 min, plus
+//     a random 0-60s from the 2nd retry. Consecutive failures are counted per
+//     tracker of a torrent, and reset by a successful announce. See
+//     getRetryInterval.
+//
+// https://github.com/transmission/transmission/blob/4.0.6/libtransmission/announcer.cc
+//
+// anacrolix/torrent re-announces earlier when it wants more peers, and retries
+// failures on its own cadence. The pacer holds those announces back until min
+// interval or the retry interval passed. The tracker's min interval and failures
+// are only known from observed responses, announces whose responses are not
+// observed are paced by the default min interval.
+
+const (
+	defaultAnnounceMinInterval = 2 * time.Minute
+)
+
+// RetryInterval is the time to wait before retrying a failed announce, plus a
+// random jitter in [0, Jitter).
+type RetryInterval struct {
+	Wait   time.Duration
+	Jitter time.Duration
+}
+
+// DefaultRetryIntervals are the Transmission retry intervals after 1, 2, 3...
+// consecutive failures, the last one repeats. See WithRetryIntervals.
+var DefaultRetryIntervals = []RetryInterval{
+	{Wait: 20 * time.Second},
+	{Wait: 5 * time.Minute, Jitter: time.Minute},
+	{Wait: 15 * time.Minute, Jitter: time.Minute},
+	{Wait: 30 * time.Minute, Jitter: time.Minute},
+	{Wait: 60 * time.Minute, Jitter: time.Minute},
+	{Wait: 120 * time.Minute, Jitter: time.Minute},
+}
+
+// ErrTooSoon is returned by ChangeHttpRequest for an announce Transmission would
+// not send yet. anacrolix/torrent announces again later.
+var ErrTooSoon = errors.New("transmission would not announce yet")
+
+// pacer holds regular announces back until the retry interval after failures
+// passed, and with pacing, until min interval since the last announce of the
+// torrent on the tracker passed.
+type pacer struct {
+	now                func() time.Time
+	rand               io.Reader
+	pacing             bool
+	maxDelay           time.Duration
+	defaultMinInterval time.Duration
+	retryIntervals     []RetryInterval
+	// sleep waits d, or returns ctx.Err() if ctx is done first.
+	sleep func(ctx context.Context, d time.Duration) error
+
+	mu sync.Mutex
+	// announce url + info_hash -> pace
+	torrents map[string]*pace
+}
+
+type pace struct {
+	// last announce sent.
+	last        time.Time
+	minInterval time.Duration
+	// consecutive failures.
+	failures int
+	retryAt  time.Time
+}
+
+func newPacer(o *options) *pacer {
+	return &pacer{
+		now:                o.now,
+		rand:               o.rand,
+		pacing:             o.pacing,
+		maxDelay:           o.maxAnnounceDelay,
+		defaultMinInterval: o.defaultMinInterval,
+		retryIntervals:     o.retryIntervals,
+		sleep:              sleep,
+		torrents:           map[string]*pace{},
+	}
+}
+
+// wait waits until the announce of event can be sent, and records it is sent.
+// It returns ErrTooSoon if it needs to wait longer than maxDelay.
+func (p *pacer) wait(ctx context.Context, id, event string) error {
+	for {
+		d := p.trySend(id, event)
+		if d <= 0 {
+			return nil
+		}
+		if d > p.maxDelay {
+			return fmt.Errorf("%w, can announce in %s", ErrTooSoon, d.Round(time.Second))
+		}
+		// Checked again after sleeping, another announce may be sent meanwhile.
+		if err := p.sleep(ctx, d); err != nil {
+			return err
+		}
+	}
+}
+
+// trySend records the announce of event is sent now, if it is not held back.
+// Otherwise it returns how long it should be held back. Only regular announces
+// are held back, until the retry interval after failures passed, or with
+// pacing, min interval since the last announce. Events never.
+func (p *pacer) trySend(id, event string) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.load(id)
+	if event == "" {
+		var at time.Time
+		switch {
+		case t.failures > 0:
+			at = t.retryAt
+		case p.pacing:
+			at = t.last.Add(t.minInterval)
+		}
+		if d := at.Sub(p.now()); d > 0 {
+			return d
+		}
+	}
+	t.last = p.now()
+	return 0
+}
+
+// load returns the pace of the torrent on the tracker, created if not found.
+// p.mu must be held.
+func (p *pacer) load(id string) *pace {
+	t, ok := p.torrents[id]
+	if !ok {
+		t = &pace{minInterval: p.defaultMinInterval}
+		p.torrents[id] = t
+	}
+	return t
+}
+
+// observe records the min interval the tracker responds, or the failure.
+func (p *pacer) observe(id string, resp *commons.AnnounceResponse, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	t := p.load(id)
+	if err != nil || resp.FailureReason != "" {
+		t.failures++
+		t.retryAt = p.now().Add(p.retryInterval(t.failures))
+		return
+	}
+	t.failures = 0
+	if resp.MinInterval > 0 {
+		t.minInterval = resp.MinIntervalDuration()
+	}
+}
+
+// retryInterval returns the time to wait after failures consecutive failures.
+func (p *pacer) retryInterval(failures int) time.Duration {
+	if len(p.retryIntervals) == 0 {
+		return 0
+	}
+	r := p.retryIntervals[min(failures, len(p.retryIntervals))-1]
+	if r.Jitter <= 0 {
+		return r.Wait
+	}
+	return r.Wait + time.Duration(commons.RandomUint32From(p.rand))%r.Jitter
+}
+
+// delete forgets the torrent on the tracker.
+func (p *pacer) delete(id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.torrents, id)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,242 @@
+package transmission
+
+import (
+	"context"
+	"errors"
+	mathrand "math/rand/v2"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithAnnouncePacing(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tr := New(WithoutScrape(), WithClock(func() time.Time { return now }), WithAnnouncePacing(30*time.Second))
+	var slept []time.Duration
+	tr.pacer.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+	const trackerA = "http://a.example.com/announce"
+	const trackerB = "http://b.example.com/announce"
+	announce := func(announce, event string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", announce+testAnnounceQuery+event, nil)
+		require.NoError(t, err)
+		return req, tr.ChangeHttpRequest(req)
+	}
+
+	_, err := announce(trackerA, "&event=started")
+	require.NoError(t, err)
+
+	// Held back longer than max delay.
+	now = now.Add(time.Minute)
+	_, err = announce(trackerA, "")
+	assert.ErrorIs(t, err, ErrTooSoon)
+	// Other trackers are not held back.
+	_, err = announce(trackerB, "&event=started")
+	require.NoError(t, err)
+
+	// Delayed until 2 min passed.
+	now = now.Add(45 * time.Second)
+	_, err = announce(trackerA, "")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{15 * time.Second}, slept)
+
+	// Events are never held back.
+	req, err := announce(trackerA, "&event=completed")
+	require.NoError(t, err)
+	assert.Len(t, slept, 1)
+
+	// Tracker's min interval.
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{Interval: 1800, MinInterval: 900}, nil)
+	now = now.Add(10 * time.Minute)
+	_, err = announce(trackerA, "")
+	assert.ErrorIs(t, err, ErrTooSoon)
+	now = now.Add(4*time.Minute + 40*time.Second)
+	_, err = announce(trackerA, "")
+	require.NoError(t, err)
+	assert.Equal(t, 20*time.Second, slept[1])
+
+	// Stopped forgets the torrent.
+	_, err = announce(trackerA, "&event=stopped")
+	require.NoError(t, err)
+	_, err = announce(trackerA, "&event=started")
+	require.NoError(t, err)
+	now = now.Add(90 * time.Second)
+	_, err = announce(trackerA, "")
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, slept[2], "min interval is reset to 2 min")
+}
+
+func TestPacer_Canceled(t *testing.T) {
+	o := defaultOptions()
+	o.pacing = true
+	o.maxAnnounceDelay = time.Hour
+	p := newPacer(o)
+	require.NoError(t, p.wait(context.Background(), "id", commons.EventStarted))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.ErrorIs(t, p.wait(ctx, "id", ""), context.Canceled)
+}
+
+func TestPacer_SentWhileSleeping(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	o := defaultOptions()
+	o.now = func() time.Time { return now }
+	o.pacing = true
+	o.maxAnnounceDelay = time.Minute
+	p := newPacer(o)
+	ctx := context.Background()
+	require.NoError(t, p.wait(ctx, "id", commons.EventStarted))
+
+	var slept []time.Duration
+	p.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		if len(slept) == 1 {
+			// Another announce wakes up first and is sent.
+			require.NoError(t, p.wait(ctx, "id", ""))
+		}
+		return nil
+	}
+	now = now.Add(90 * time.Second)
+	assert.ErrorIs(t, p.wait(ctx, "id", ""), ErrTooSoon, "checked again after sleeping")
+	assert.Equal(t, []time.Duration{30 * time.Second}, slept)
+}
+
+func TestWithRetryIntervals(t *testing.T) {
+	testCases := []struct {
+		name string
+		opts []Option
+		// retry waits after 1, 2, 3... failures.
+		wantMin []time.Duration
+		wantMax []time.Duration
+	}{
+		{
+			name:    "transmission",
+			wantMin: []time.Duration{20 * time.Second, 5 * time.Minute, 15 * time.Minute, 30 * time.Minute, 60 * time.Minute, 120 * time.Minute, 120 * time.Minute},
+			wantMax: []time.Duration{20 * time.Second, 6 * time.Minute, 16 * time.Minute, 31 * time.Minute, 61 * time.Minute, 121 * time.Minute, 121 * time.Minute},
+		},
+		{
+			name:    "custom",
+			opts:    []Option{WithRetryIntervals(RetryInterval{Wait: 5 * time.Second}, RetryInterval{Wait: 15 * time.Second, Jitter: 5 * time.Second})},
+			wantMin: []time.Duration{5 * time.Second, 15 * time.Second, 15 * time.Second},
+			wantMax: []time.Duration{5 * time.Second, 20 * time.Second, 20 * time.Second},
+		},
+		{
+			name:    "retry right away",
+			opts:    []Option{WithRetryIntervals()},
+			wantMin: []time.Duration{0, 0},
+			wantMax: []time.Duration{0, 0},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now := time.Unix(1700000000, 0)
+			opts := append([]Option{
+				WithoutScrape(),
+				WithClock(func() time.Time { return now }),
+				WithRand(mathrand.NewChaCha8([32]byte{1})),
+				WithAnnouncePacing(3 * time.Hour),
+			}, tc.opts...)
+			tr := New(opts...)
+			var slept time.Duration
+			tr.pacer.sleep = func(ctx context.Context, d time.Duration) error {
+				slept = d
+				now = now.Add(d)
+				return nil
+			}
+
+			req, err := http.NewRequest("GET", "http://example.com/announce"+testAnnounceQuery+"&event=started", nil)
+			require.NoError(t, err)
+			require.NoError(t, tr.ChangeHttpRequest(req))
+			for i := range tc.wantMin {
+				slept = 0
+				tr.ObserveAnnounce(req, nil, errors.New("timeout"))
+				req, err = http.NewRequest("GET", "http://example.com/announce"+testAnnounceQuery, nil)
+				require.NoError(t, err)
+				require.NoError(t, tr.ChangeHttpRequest(req))
+				assert.GreaterOrEqual(t, slept, tc.wantMin[i], "retry %d", i+1)
+				assert.LessOrEqual(t, slept, tc.wantMax[i], "retry %d", i+1)
+			}
+		})
+	}
+}
+
+func TestAnnouncePacing_Failures(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tr := New(WithoutScrape(), WithClock(func() time.Time { return now }), WithAnnouncePacing(10*time.Second), WithDefaultMinInterval(time.Minute))
+	var slept []time.Duration
+	tr.pacer.sleep = func(ctx context.Context, d time.Duration) error {
+		slept = append(slept, d)
+		now = now.Add(d)
+		return nil
+	}
+	announce := func(event string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", "http://example.com/announce"+testAnnounceQuery+event, nil)
+		require.NoError(t, err)
+		return req, tr.ChangeHttpRequest(req)
+	}
+
+	req, err := announce("&event=started")
+	require.NoError(t, err)
+
+	// Failure reason is a failure, regular announces wait for the retry.
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{FailureReason: "overloaded"}, nil)
+	_, err = announce("")
+	assert.ErrorIs(t, err, ErrTooSoon)
+	now = now.Add(15 * time.Second)
+	req, err = announce("")
+	require.NoError(t, err)
+	assert.Equal(t, []time.Duration{5 * time.Second}, slept)
+
+	// Events are not held back by failures.
+	tr.ObserveAnnounce(req, nil, errors.New("timeout"))
+	req, err = announce("&event=completed")
+	require.NoError(t, err)
+	assert.Len(t, slept, 1)
+
+	// Success resets failures, default min interval applies again.
+	tr.ObserveAnnounce(req, &commons.AnnounceResponse{Interval: 1800}, nil)
+	now = now.Add(55 * time.Second)
+	_, err = announce("")
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Second, slept[1])
+
+	// Stopped is never held back, and its failure is not counted.
+	tr.ObserveAnnounce(req, nil, errors.New("timeout"))
+	req, err = announce("&event=stopped")
+	require.NoError(t, err)
+	tr.ObserveAnnounce(req, nil, errors.New("timeout"))
+	_, err = announce("&event=started")
+	require.NoError(t, err)
+	assert.Len(t, slept, 2)
+}
+
+func TestRetryBackoff_WithoutPacing(t *testing.T) {
+	tr := New(WithoutScrape())
+	announce := func(event string) (*http.Request, error) {
+		req, err := http.NewRequest("GET", "http://example.com/announce"+testAnnounceQuery+event, nil)
+		require.NoError(t, err)
+		return req, tr.ChangeHttpRequest(req)
+	}
+
+	req, err := announce("&event=started")
+	require.NoError(t, err)
+	// Not paced without WithAnnouncePacing.
+	_, err = announce("")
+	require.NoError(t, err)
+
+	tr.ObserveAnnounce(req, nil, errors.New("timeout"))
+	_, err = announce("")
+	assert.ErrorIs(t, err, ErrTooSoon, "held back until the retry")
+	_, err = announce("&event=completed")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,66 @@
+package transmission
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	peerIDLen = 20
+	// bep20 prefix "-TRxyzb-".
+	peerIDPrefixLen = 8
+)
+
+// newPeerID generates peer_id the same way as Transmission tr_peerIdInit():
+// the prefix, 11 random chars from AlphaNumLower, and a checksum char making the
+// sum of the 12 random chars' indexes a multiple of 36.
+//
+// https://github.com/transmission/transmission/blob/4.0.6/libtransmission/session.cc
+//
+// https://github.com/transmission/transmission/blob/3.00/libtransmission/session.c
+func newPeerID(r io.Reader, bep20 string) string {
+	pool := commons.AlphaNumLower
+	base := len(pool)
+
+	id := []byte(bep20)
+	total := 0
+	for _, c := range commons.RandomBytesFrom(r, peerIDLen-1-len(bep20)) {
+		val := int(c) % base
+		total += val
+		id = append(id, pool[val])
+	}
+	val := 0
+	if total%base != 0 {
+		val = base - total%base
+	}
+	return string(append(id, pool[val]))
+}
+
+// ValidatePeerID checks the peer_id is generated by Transmission: "-TRxyzb-",
+// then 12 chars from 0-9a-z with sum of their indexes a multiple of 36.
+func ValidatePeerID(peerID string) error {
+	if len(peerID) != peerIDLen {
+		return fmt.Errorf("peer_id length %d, want %d", len(peerID), peerIDLen)
+	}
+	prefix := peerID[:peerIDPrefixLen]
+	if !strings.HasPrefix(prefix, "-TR") || prefix[peerIDPrefixLen-1] != '-' {
+		return fmt.Errorf("peer_id prefix %q is not Transmission", prefix)
+	}
+
+	base := len(commons.AlphaNumLower)
+	total := 0
+	for _, c := range peerID[peerIDPrefixLen:] {
+		val := strings.IndexRune(commons.AlphaNumLower, c)
+		if val == -1 {
+			return fmt.Errorf("peer_id has invalid char %q", c)
+		}
+		total += val
+	}
+	if total%base != 0 {
+		return fmt.Errorf("peer_id checksum mismatch")
+	}
+	return nil
+}
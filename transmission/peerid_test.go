@@ -0,0 +1,46 @@
+package transmission
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPeerID(t *testing.T) {
+	for _, bep20 := range []string{transmissionV300Bep20, transmissionV406Bep20} {
+		for i := 0; i < 100; i++ {
+			peerID := newPeerID(rand.Reader, bep20)
+			assert.Len(t, peerID, 20)
+			assert.Equal(t, bep20, peerID[:8])
+			assert.NoError(t, ValidatePeerID(peerID), "invalid peer_id %s", peerID)
+		}
+	}
+}
+
+func TestValidatePeerID(t *testing.T) {
+	testCases := []struct {
+		name   string
+		peerID string
+		err    string
+	}{
+		{name: "valid", peerID: "-TR4060-000000000000"},
+		{name: "valid checksum", peerID: "-TR4060-zzzzzzzzzzzb"},
+		{name: "valid checksum 2", peerID: "-TR3000-a0000000000q"},
+		{name: "too short", peerID: "-TR4060-00000", err: "length"},
+		{name: "not transmission", peerID: "-qB4650-000000000000", err: "prefix"},
+		{name: "invalid char", peerID: "-TR4060-00000000000A", err: "invalid char"},
+		{name: "checksum mismatch", peerID: "-TR4060-000000000001", err: "checksum"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := ValidatePeerID(tc.peerID)
+			if tc.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tc.err)
+			}
+		})
+	}
+}
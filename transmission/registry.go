@@ -0,0 +1,31 @@
+package transmission
+
+import (
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+// released is the release month of each version, unknown ones are omitted.
+var released = map[string]string{
+	"3.00":  "2020-05",
+	"4.0.5": "2023-12",
+	"4.0.6": "2024-05",
+}
+
+func init() {
+	for _, v := range SupportedVersions() {
+		info := commons.ProfileInfo{
+			Client:    "transmission",
+			Version:   v,
+			Engine:    "libtransmission",
+			Released:  released[v],
+			Protocols: []string{commons.ProtocolHTTP, commons.ProtocolHTTPS, commons.ProtocolUDP},
+		}
+		commons.RegisterProfile(info, func() (commons.Director, error) {
+			s, err := NewVersion(v)
+			if err != nil {
+				return nil, err
+			}
+			return s, nil
+		})
+	}
+}
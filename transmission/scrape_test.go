@@ -1,113 +1,26 @@
 package transmission
 
 import (
-	"net/url"
-	"testing"
-
+	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
 	"time"
 
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
 	"github.com/stretchr/testify/assert"
-	"golang.org/x/time/rate"
+	"github.com/stretchr/testify/require"
 )
 
-func TestScrapeURL(t *testing.T) {
-	infoHash := "1234567890abcdefghij" // 20 bytes
-	escapedInfoHash := url.QueryEscape(infoHash)
-
-	testCases := []struct {
-		name                string
-		announceURLStr      string
-		infoHash            string
-		privateTrackerQuery string
-		expectedScrapeURL   string // Expected URL string, or empty if nil expected
-	}{
-		{
-			name:                "HTTP announce URL",
-			announceURLStr:      "http://tracker.example.com/announce",
-			infoHash:            infoHash,
-			privateTrackerQuery: "",
-			expectedScrapeURL:   "http://tracker.example.com/scrape?info_hash=" + escapedInfoHash,
-		},
-		{
-			name:                "HTTPS announce URL",
-			announceURLStr:      "https://secure.tracker.org:8080/announce",
-			infoHash:            infoHash,
-			privateTrackerQuery: "",
-			expectedScrapeURL:   "https://secure.tracker.org:8080/scrape?info_hash=" + escapedInfoHash,
-		},
-		{
-			name:                "Announce URL with existing query",
-			announceURLStr:      "http://tracker.example.com/announce?passkey=xyz",
-			infoHash:            infoHash,
-			privateTrackerQuery: "",
-			expectedScrapeURL:   "http://tracker.example.com/scrape?info_hash=" + escapedInfoHash, // Original query should be replaced
-		},
-		{
-			name:                "Announce URL not ending in /announce",
-			announceURLStr:      "http://tracker.example.com/announce_extra",
-			infoHash:            infoHash,
-			privateTrackerQuery: "",
-			expectedScrapeURL:   "", // Should return nil
-		},
-		{
-			name:                "Announce URL path only /",
-			announceURLStr:      "http://tracker.example.com/",
-			infoHash:            infoHash,
-			privateTrackerQuery: "",
-			expectedScrapeURL:   "", // Should return nil
-		},
-		{
-			name:                "Announce URL no path",
-			announceURLStr:      "http://tracker.example.com",
-			infoHash:            infoHash,
-			privateTrackerQuery: "",
-			expectedScrapeURL:   "", // Should return nil
-		},
-		{
-			name:                "With private tracker query",
-			announceURLStr:      "http://private.tracker/announce",
-			infoHash:            infoHash,
-			privateTrackerQuery: "passkey=abc&uid=123",
-			expectedScrapeURL:   "http://private.tracker/scrape?passkey=abc&uid=123&info_hash=" + escapedInfoHash,
-		},
-		{
-			name:                "Announce URL with complex path",
-			announceURLStr:      "http://tracker.example.com/tracker/announce",
-			infoHash:            infoHash,
-			privateTrackerQuery: "",
-			expectedScrapeURL:   "http://tracker.example.com/tracker/scrape?info_hash=" + escapedInfoHash,
-		},
-	}
-
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			announceURL, err := url.Parse(tc.announceURLStr)
-			if err != nil {
-				t.Fatalf("Failed to parse announce URL '%s': %v", tc.announceURLStr, err)
-			}
-
-			actualURL := scrapeURL(announceURL, tc.infoHash, tc.privateTrackerQuery)
-
-			if tc.expectedScrapeURL == "" {
-				assert.Nil(t, actualURL, "Expected nil URL")
-			} else {
-				assert.NotNil(t, actualURL, "Expected non-nil URL")
-				if actualURL != nil {
-					expectedParsedURL, _ := url.Parse(tc.expectedScrapeURL)
-					assert.Equal(t, expectedParsedURL.Scheme, actualURL.Scheme, "Scheme mismatch")
-					assert.Equal(t, expectedParsedURL.Host, actualURL.Host, "Host mismatch")
-					assert.Equal(t, expectedParsedURL.Path, actualURL.Path, "Path mismatch")
-					assert.Equal(t, expectedParsedURL.Query(), actualURL.Query(), "Query mismatch")
-					assert.Equal(t, tc.expectedScrapeURL, actualURL.String(), "Full URL string mismatch")
-				}
-			}
-		})
-	}
+// scrapeNow moves the clock back, so the first scrape, 1-10s after the
+// announce plus the interval, is sent right away with a short interval.
+func scrapeNow() Option {
+	return WithClock(func() time.Time { return time.Now().Add(-time.Minute) })
 }
 
-func TestScrapeTaskRun_Success(t *testing.T) {
+func TestScrape(t *testing.T) {
 	requestReceived := make(chan struct{}, 1)
 
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -116,32 +29,74 @@ func TestScrapeTaskRun_Success(t *testing.T) {
 		assert.Equal(t, "Transmission/4.0.6", r.Header.Get("User-Agent"), "Expected User-Agent header")
 		assert.Equal(t, "*/*", r.Header.Get("Accept"), "Expected Accept header")
 		assert.NotEmpty(t, r.Header.Get("Accept-Encoding"), "Expected Accept-Encoding header")
-		assert.Equal(t, "test_info_hash", r.URL.Query().Get("info_hash"), "Expected info_hash query param")
+		assert.Equal(t, "123", r.URL.Query().Get("info_hash"), "Expected info_hash query param")
 		assert.Equal(t, "a_key", r.URL.Query().Get("auth"), "Expected auth query param")
 
-		requestReceived <- struct{}{}
+		select {
+		case requestReceived <- struct{}{}:
+		default:
+		}
 		w.WriteHeader(http.StatusOK)
 	}))
 	defer server.Close()
 
-	serverURL, _ := url.Parse(server.URL + "/announce")
-	query := url.Values{}
-	query.Add("info_hash", "wrong_hash")
-	query.Add("auth", "wrong_key")
-	serverURL.RawQuery = query.Encode()
+	tr := New(WithHTTPClient(server.Client()), WithScrapeInterval(10*time.Millisecond), scrapeNow())
+	defer tr.Close(context.Background())
+	req, err := http.NewRequest("GET", server.URL+"/announce?auth=a_key&"+testAnnounceQuery[1:], nil)
+	require.NoError(t, err)
+	require.NoError(t, tr.ChangeHttpRequest(req))
 
-	tr := &mimickTransmission{
-		// Allow requests immediately for the test
-		scrapeRateLimiter: rate.NewLimiter(rate.Inf, 1),
+	select {
+	case <-requestReceived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the mock server to receive the scrape request")
 	}
+}
+
+func TestClose(t *testing.T) {
+	requestReceived := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case requestReceived <- struct{}{}:
+		default:
+		}
+		// Hang until the request is canceled.
+		<-r.Context().Done()
+	}))
+	defer server.Close()
 
-	task := newScrapeTask(tr, serverURL, "test_info_hash", "auth=a_key")
+	path := filepath.Join(t.TempDir(), "identities.json")
+	store, err := commons.NewFileStore(path)
+	require.NoError(t, err)
+	tr := New(WithHTTPClient(server.Client()), WithStore(store), WithScrapeInterval(10*time.Millisecond), scrapeNow())
 
-	go task.run()
+	req, err := http.NewRequest("GET", server.URL+"/announce?compact=1&downloaded=0&event=started&info_hash=123&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	require.NoError(t, tr.ChangeHttpRequest(req))
+	id := commons.PerTrackerTorrentID(req.URL, "123")
+	assert.True(t, tr.scraper.Scheduled(id))
 
+	// In-flight scrape.
 	select {
 	case <-requestReceived:
 	case <-time.After(2 * time.Second):
 		t.Fatal("Timed out waiting for the mock server to receive the scrape request")
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, tr.Close(ctx))
+
+	assert.False(t, tr.scraper.Scheduled(id), "scheduled scrapes are stopped")
+	_, err = os.Stat(path)
+	assert.NoError(t, err, "identities are persisted")
+
+	// Announces still work, without scrapes.
+	req, err = http.NewRequest("GET", server.URL+"/announce?compact=1&downloaded=0&event=started&info_hash=456&key=1&left=0&peer_id=OLD&port=1&supportcrypto=1&uploaded=0", nil)
+	require.NoError(t, err)
+	require.NoError(t, tr.ChangeHttpRequest(req))
+	assert.False(t, tr.scraper.Scheduled(commons.PerTrackerTorrentID(req.URL, "456")))
+
+	// Close again is fine.
+	require.NoError(t, tr.Close(ctx))
 }
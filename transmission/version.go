@@ -0,0 +1,125 @@
+package transmission
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	DefaultVersion = "4.0.6"
+
+	transmissionV300Bep20 = "-TR3000-"
+	transmissionV405Bep20 = "-TR4050-"
+	transmissionV406Bep20 = "-TR4060-"
+	transmissionV410Bep20 = "-TR4100-"
+)
+
+// version holds what differs between Transmission versions.
+type version struct {
+	bep20 string
+	// format of the random uint32 key.
+	keyFormat string
+	headers   []commons.Header
+	queryDefs []*commons.QueryDef
+}
+
+var (
+	// transmission 3.00:
+	//
+	// https://github.com/transmission/transmission/blob/3.00/libtransmission/announcer-http.c
+	//
+	// key is "%x", "corrupt" is before "event". Built with older libcurl.
+	v300QueryDefs = []*commons.QueryDef{
+		commons.MustHaveDef("info_hash"),
+		commons.MustHaveDef("peer_id"),
+		commons.MustHaveDef("port"),
+		commons.MustHaveDef("uploaded"),
+		commons.MustHaveDef("downloaded"),
+		commons.MustHaveDef("left"),
+		commons.MustHaveDef("numwant"),
+		commons.MustHaveDef("key"),
+		commons.MustHaveDef("compact"),
+		commons.MustHaveDef("supportcrypto"),
+		commons.OptionalDef("requirecrypto"),
+		commons.OptionalDef("corrupt"),
+		commons.OptionalDef("event"),
+		commons.OptionalDef("trackerid"),
+	}
+
+	// transmission 4.x:
+	//
+	// https://github.com/transmission/transmission/blob/4.0.6/libtransmission/announcer-http.cc
+	v4QueryDefs = []*commons.QueryDef{
+		commons.MustHaveDef("info_hash"),
+		commons.MustHaveDef("peer_id"),
+		commons.MustHaveDef("port"),
+		commons.MustHaveDef("uploaded"),
+		commons.MustHaveDef("downloaded"),
+		commons.MustHaveDef("left"),
+		commons.MustHaveDef("numwant"),
+		commons.MustHaveDef("key"),
+		commons.MustHaveDef("compact"),
+		commons.MustHaveDef("supportcrypto"),
+		commons.OptionalDef("requirecrypto"),
+		commons.OptionalDef("event"),
+		commons.OptionalDef("corrupt"),
+		commons.OptionalDef("trackerid"),
+	}
+
+	versions = map[string]*version{
+		"3.00": {
+			bep20:     transmissionV300Bep20,
+			keyFormat: "%x",
+			headers:   curlHeaders("3.00", "deflate, gzip"),
+			queryDefs: v300QueryDefs,
+		},
+		"4.0.5": {
+			bep20:     transmissionV405Bep20,
+			keyFormat: "%08X",
+			headers:   curlHeaders("4.0.5", "deflate, gzip, br, zstd"),
+			queryDefs: v4QueryDefs,
+		},
+		"4.0.6": {
+			bep20:     transmissionV406Bep20,
+			keyFormat: "%08X",
+			headers:   curlHeaders("4.0.6", "deflate, gzip, br, zstd"),
+			queryDefs: v4QueryDefs,
+		},
+		"4.1.0": {
+			bep20:     transmissionV410Bep20,
+			keyFormat: "%08X",
+			headers:   curlHeaders("4.1.0", "deflate, gzip, br, zstd"),
+			queryDefs: v4QueryDefs,
+		},
+	}
+)
+
+// curlHeaders returns the headers all Transmission versions send through
+// libcurl, Accept-Encoding is every encoding the libcurl supports.
+func curlHeaders(version, acceptEncoding string) []commons.Header {
+	return []commons.Header{
+		{Name: "Accept-Encoding", Value: acceptEncoding},
+		{Name: "User-Agent", Value: "Transmission/" + version},
+		{Name: "Accept", Value: "*/*"},
+	}
+}
+
+// SupportedVersions returns the Transmission versions can be mimicked, sorted.
+func SupportedVersions() []string {
+	res := make([]string, 0, len(versions))
+	for v := range versions {
+		res = append(res, v)
+	}
+	slices.Sort(res)
+	return res
+}
+
+func lookupVersion(v string) (*version, error) {
+	got, ok := versions[v]
+	if !ok {
+		return nil, fmt.Errorf("unsupported Transmission version %s", v)
+	}
+	return got, nil
+}
@@ -0,0 +1,110 @@
+package transmission
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSupportedVersions(t *testing.T) {
+	assert.Equal(t, []string{"3.00", "4.0.5", "4.0.6", "4.1.0"}, SupportedVersions())
+}
+
+func TestNewVersion(t *testing.T) {
+	testCases := []struct {
+		version        string
+		bep20          string
+		acceptEncoding string
+		key            *regexp.Regexp
+		expectedOrder  []string
+		wantErr        bool
+	}{
+		{
+			version:        "3.00",
+			bep20:          "-TR3000-",
+			acceptEncoding: "deflate, gzip",
+			key:            regexp.MustCompile(`^[0-9a-f]{1,8}$`),
+			expectedOrder: []string{
+				"info_hash", "peer_id", "port", "uploaded", "downloaded", "left",
+				"numwant", "key", "compact", "supportcrypto", "corrupt", "event",
+			},
+		},
+		{
+			version:        "4.0.5",
+			bep20:          "-TR4050-",
+			acceptEncoding: "deflate, gzip, br, zstd",
+			key:            regexp.MustCompile(`^[0-9A-F]{8}$`),
+			expectedOrder: []string{
+				"info_hash", "peer_id", "port", "uploaded", "downloaded", "left",
+				"numwant", "key", "compact", "supportcrypto", "event", "corrupt",
+			},
+		},
+		{
+			version:        "4.0.6",
+			bep20:          "-TR4060-",
+			acceptEncoding: "deflate, gzip, br, zstd",
+			key:            regexp.MustCompile(`^[0-9A-F]{8}$`),
+			expectedOrder: []string{
+				"info_hash", "peer_id", "port", "uploaded", "downloaded", "left",
+				"numwant", "key", "compact", "supportcrypto", "event", "corrupt",
+			},
+		},
+		{
+			version:        "4.1.0",
+			bep20:          "-TR4100-",
+			acceptEncoding: "deflate, gzip, br, zstd",
+			key:            regexp.MustCompile(`^[0-9A-F]{8}$`),
+			expectedOrder: []string{
+				"info_hash", "peer_id", "port", "uploaded", "downloaded", "left",
+				"numwant", "key", "compact", "supportcrypto", "event", "corrupt",
+			},
+		},
+		{
+			version: "2.94",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.version, func(t *testing.T) {
+			tr, err := NewVersion(tc.version)
+			if tc.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			req, err := http.NewRequest("GET", fmt.Sprintf(
+				"http://example.com/tracker/announce?compact=1&corrupt=10&downloaded=0&event=started&info_hash=%s&key=1234&left=0&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0",
+				"12345678901234567890"), nil)
+			require.NoError(t, err)
+			require.NoError(t, tr.ChangeHttpRequest(req))
+
+			assert.Equal(t, "Transmission/"+tc.version, req.Header.Get("User-Agent"))
+			assert.Equal(t, tc.acceptEncoding, req.Header.Get("Accept-Encoding"))
+			assert.Equal(t, "*/*", req.Header.Get("Accept"))
+			assert.Len(t, req.Header, 3)
+
+			q, err := commons.QueryParamsFromRawQueryStr(req.URL.RawQuery)
+			require.NoError(t, err)
+			names := []string{}
+			for _, p := range q {
+				names = append(names, p.Name)
+				switch p.Name {
+				case "peer_id":
+					assert.Len(t, p.Value, 20)
+					assert.True(t, strings.HasPrefix(p.Value, tc.bep20))
+				case "key":
+					assert.Regexp(t, tc.key, p.Value)
+				}
+			}
+			assert.Equal(t, tc.expectedOrder, names)
+		})
+	}
+}
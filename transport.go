@@ -0,0 +1,196 @@
+package camouflagetorrentclients
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+// AnnounceObserver is implemented by profiles reacting to tracker responses,
+// e.g. interval, tracker id or failures, the same as the real client.
+type AnnounceObserver interface {
+	// ObserveAnnounce is called with the announce request as sent, after
+	// ChangeHttpRequest, and the tracker's response. err is set if the tracker
+	// could not be reached, responded non 200 or not bencoded, resp is nil then.
+	// A tracker's failure reason is not an error, see
+	// commons.AnnounceResponse.FailureReason.
+	ObserveAnnounce(req *http.Request, resp *commons.AnnounceResponse, err error)
+}
+
+// Install sets d as cfg.HttpRequestDirector, and wraps cfg.TrackerDialContext
+// to call the commons.OnDial hooks of announces, e.g. libtorrent 2.0 picks the
+// key by the address family of the connection. If d implements
+// AnnounceObserver, e.g. Directors or Switcher, the dialer also hands d the
+// responses of announces sent by anacrolix/torrent. Set cfg.TrackerDialContext
+// before Install.
+//
+// anacrolix/torrent announces with its own http.Transport, and
+// torrent.ClientConfig has no field to replace it. The dialer is the only hook
+// on the way: each announce dials a new connection with keep-alives disabled,
+// and the response is read off the connection when it is closed. Only plain
+// HTTP announces are observed, HTTPS responses are encrypted on the connection.
+func Install(cfg *torrent.ClientConfig, d HttpRequestDirector) {
+	cfg.HttpRequestDirector = d.ChangeHttpRequest
+	if _, ok := d.(AnnounceObserver); ok {
+		cfg.HttpRequestDirector = func(req *http.Request) error {
+			if err := d.ChangeHttpRequest(req); err != nil {
+				return err
+			}
+			if req.URL.Scheme == "http" && isAnnounce(req) {
+				a := &pendingAnnounce{director: d, req: req}
+				*req = *req.WithContext(context.WithValue(req.Context(), pendingAnnounceKey{}, a))
+			}
+			return nil
+		}
+	}
+
+	dial := cfg.TrackerDialContext
+	if dial == nil {
+		// The same as http.Transport without DialContext.
+		dial = (&net.Dialer{}).DialContext
+	}
+	cfg.TrackerDialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if hook := commons.DialHook(ctx); hook != nil && err == nil {
+			hook(conn)
+		}
+		a, ok := ctx.Value(pendingAnnounceKey{}).(*pendingAnnounce)
+		if !ok {
+			return conn, err
+		}
+		if err != nil {
+			a.done(nil, err)
+			return nil, err
+		}
+		return &announceConn{Conn: conn, announce: a}, nil
+	}
+}
+
+// pendingAnnounceKey is the context key of the pendingAnnounce of a request.
+type pendingAnnounceKey struct{}
+
+// pendingAnnounce is an announce waiting for its response.
+type pendingAnnounce struct {
+	director HttpRequestDirector
+	req      *http.Request
+	once     sync.Once
+}
+
+// done hands the response to the director, only the first time.
+func (a *pendingAnnounce) done(resp *commons.AnnounceResponse, err error) {
+	a.once.Do(func() {
+		observeAnnounce(a.director, a.req, resp, err)
+	})
+}
+
+// announceConn records the bytes read off the connection of an announce, and
+// parses the response when http.Transport closes it.
+type announceConn struct {
+	net.Conn
+	announce *pendingAnnounce
+
+	mu      sync.Mutex
+	read    bytes.Buffer
+	readErr error
+}
+
+func (c *announceConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.mu.Lock()
+	c.read.Write(b[:n])
+	if err != nil && err != io.EOF && c.readErr == nil {
+		c.readErr = err
+	}
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *announceConn) Close() error {
+	err := c.Conn.Close()
+	c.mu.Lock()
+	data, readErr := c.read.Bytes(), c.readErr
+	c.mu.Unlock()
+	c.announce.done(readAnnounceResponse(c.announce.req, data, readErr))
+	return err
+}
+
+// readAnnounceResponse parses the response of req read off the connection.
+// readErr is the error the connection broke with, if any.
+func readAnnounceResponse(req *http.Request, data []byte, readErr error) (*commons.AnnounceResponse, error) {
+	resp, body, err := readResponse(req, data)
+	if err != nil {
+		// Closed by http.Transport, when the request timed out or canceled.
+		if errors.Is(readErr, net.ErrClosed) && req.Context().Err() != nil {
+			return nil, req.Context().Err()
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+		return nil, err
+	}
+	return parseAnnounceResponse(resp, body)
+}
+
+// readResponse parses the response and its body, decompressed the same way as
+// http.Transport.
+func readResponse(req *http.Request, data []byte) (*http.Response, []byte, error) {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body := io.Reader(resp.Body)
+	// http.Transport asks for gzip and decompresses it, unless the request has
+	// its own Accept-Encoding.
+	if resp.Header.Get("Content-Encoding") == "gzip" && req.Header.Get("Accept-Encoding") == "" {
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+		body = gz
+	}
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return resp, b, nil
+}
+
+// observeAnnounce hands the announce response to d, if it implements
+// AnnounceObserver.
+func observeAnnounce(d HttpRequestDirector, req *http.Request, resp *commons.AnnounceResponse, err error) {
+	o, ok := d.(AnnounceObserver)
+	if !ok {
+		return
+	}
+	// Canceled by the caller, not a tracker error. Timeouts are.
+	if err != nil && errors.Is(req.Context().Err(), context.Canceled) {
+		return
+	}
+	o.ObserveAnnounce(req, resp, err)
+}
+
+// isAnnounce reports whether req is an announce request.
+func isAnnounce(req *http.Request) bool {
+	return !commons.IsScrape(req.URL) && req.URL.Query().Has("info_hash")
+}
+
+// parseAnnounceResponse parses the body of the announce response, an error if
+// the tracker did not respond 200.
+func parseAnnounceResponse(resp *http.Response, body []byte) (*commons.AnnounceResponse, error) {
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("tracker responded %s", resp.Status)
+	}
+	return commons.ParseAnnounceResponse(body)
+}
@@ -0,0 +1,340 @@
+package camouflagetorrentclients
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anacrolix/torrent"
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+	"github.com/charleshuang3/camouflagetorrentclients/transmission"
+	"github.com/charleshuang3/camouflagetorrentclients/utorrent"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type observedAnnounce struct {
+	url  string
+	resp *commons.AnnounceResponse
+	err  error
+}
+
+type announceRecorder struct {
+	AnnounceLog
+	mu       sync.Mutex
+	observed []observedAnnounce
+}
+
+func (a *announceRecorder) ObserveAnnounce(req *http.Request, resp *commons.AnnounceResponse, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.observed = append(a.observed, observedAnnounce{url: commons.AnnounceURL(req.URL), resp: resp, err: err})
+}
+
+// wait returns the observed announces once there are n, responses are observed
+// when http.Transport closes the connection.
+func (a *announceRecorder) wait(t *testing.T, n int) []observedAnnounce {
+	var observed []observedAnnounce
+	require.Eventually(t, func() bool {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		observed = append([]observedAnnounce(nil), a.observed...)
+		return len(observed) >= n
+	}, 5*time.Second, 10*time.Millisecond)
+	require.Len(t, observed, n)
+	return observed
+}
+
+const testAnnounceResponse = "d8:completei5e10:incompletei3e8:intervali1800e12:min intervali900e5:peers0:10:tracker id3:abce"
+
+func newTestTracker(t *testing.T, newServer func(http.Handler) *httptest.Server) *httptest.Server {
+	server := newServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/down/announce":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/slow/announce":
+			<-r.Context().Done()
+		case "/gzip/announce":
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			io.WriteString(gz, testAnnounceResponse)
+			gz.Close()
+		case "/scrape":
+			io.WriteString(w, "d5:filesdee")
+		default:
+			io.WriteString(w, testAnnounceResponse)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// send sends req the way anacrolix/torrent sends announces, after
+// cfg.HttpRequestDirector: a new http.Transport dialing with
+// cfg.TrackerDialContext, keep-alives disabled.
+func send(cfg *torrent.ClientConfig, req *http.Request) (string, error) {
+	req.Host = req.URL.Host
+	c := &http.Client{Transport: &http.Transport{
+		DialContext:       cfg.TrackerDialContext,
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		DisableKeepAlives: true,
+	}}
+	resp, err := c.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, resp.Body)
+	return buf.String(), err
+}
+
+// announceQuery is the query of anacrolix/torrent announces.
+func announceQuery(infoHash, event string) string {
+	q := "?compact=1&downloaded=0&info_hash=" + infoHash + "&key=1234&left=0&peer_id=OLD_PEER_ID&port=3456&supportcrypto=1&uploaded=0"
+	if event != "" {
+		q += "&event=" + event
+	}
+	return q
+}
+
+// announce sends an announce of infoHash to the tracker u the way
+// anacrolix/torrent does.
+func announce(t *testing.T, ctx context.Context, cfg *torrent.ClientConfig, u, infoHash, event string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u+announceQuery(infoHash, event), nil)
+	require.NoError(t, err)
+	if err := cfg.HttpRequestDirector(req); err != nil {
+		return "", err
+	}
+	return send(cfg, req)
+}
+
+func TestInstall(t *testing.T) {
+	server := newTestTracker(t, httptest.NewServer)
+	rec := &announceRecorder{}
+	cfg := torrent.NewDefaultClientConfig()
+	Install(cfg, NewDirectors(&AnnounceLog{}, utorrent.New(), rec))
+	ctx := context.Background()
+
+	// anacrolix/torrent still reads the body.
+	body, err := announce(t, ctx, cfg, server.URL+"/announce", "hash1", "started")
+	require.NoError(t, err)
+	assert.Equal(t, testAnnounceResponse, body)
+	observed := rec.wait(t, 1)
+	assert.Equal(t, server.URL+"/announce", observed[0].url)
+	assert.NoError(t, observed[0].err)
+	assert.Equal(t, &commons.AnnounceResponse{
+		Interval:    1800,
+		MinInterval: 900,
+		TrackerID:   "abc",
+		Complete:    5,
+		Incomplete:  3,
+	}, observed[0].resp)
+
+	// Scrapes are not observed.
+	_, err = announce(t, ctx, cfg, server.URL+"/scrape", "hash1", "")
+	require.NoError(t, err)
+
+	// Tracker down.
+	_, err = announce(t, ctx, cfg, server.URL+"/down/announce", "hash1", "")
+	require.NoError(t, err)
+	observed = rec.wait(t, 2)
+	assert.Nil(t, observed[1].resp)
+	assert.ErrorContains(t, observed[1].err, "503")
+
+	// Canceled by anacrolix/torrent is not a tracker error.
+	cancelCtx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = announce(t, cancelCtx, cfg, server.URL+"/slow/announce", "hash1", "")
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// Timeouts are.
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = announce(t, timeoutCtx, cfg, server.URL+"/slow/announce", "hash1", "")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	observed = rec.wait(t, 3)
+	assert.Equal(t, server.URL+"/slow/announce", observed[2].url)
+	assert.ErrorIs(t, observed[2].err, context.DeadlineExceeded)
+
+	// Tracker unreachable.
+	unreachable := newTestTracker(t, httptest.NewServer)
+	unreachable.Close()
+	_, err = announce(t, ctx, cfg, unreachable.URL+"/announce", "hash1", "")
+	assert.Error(t, err)
+	observed = rec.wait(t, 4)
+	assert.Error(t, observed[3].err)
+}
+
+func TestInstall_Gzip(t *testing.T) {
+	server := newTestTracker(t, httptest.NewServer)
+	rec := &announceRecorder{}
+	cfg := torrent.NewDefaultClientConfig()
+	Install(cfg, rec)
+
+	// Without Accept-Encoding in the request, http.Transport asks for gzip and
+	// decompresses it.
+	body, err := announce(t, context.Background(), cfg, server.URL+"/gzip/announce", "hash1", "")
+	require.NoError(t, err)
+	assert.Equal(t, testAnnounceResponse, body)
+	observed := rec.wait(t, 1)
+	assert.NoError(t, observed[0].err)
+	assert.Equal(t, "abc", observed[0].resp.TrackerID)
+}
+
+func TestInstall_TrackerID(t *testing.T) {
+	var mu sync.Mutex
+	var trackerIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		trackerIDs = append(trackerIDs, r.URL.Query().Get("trackerid"))
+		mu.Unlock()
+		io.WriteString(w, testAnnounceResponse)
+	}))
+	t.Cleanup(server.Close)
+
+	rec := &announceRecorder{}
+	cfg := torrent.NewDefaultClientConfig()
+	Install(cfg, NewDirectors(transmission.New(transmission.WithoutScrape()), rec))
+	const infoHash = "%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9"
+
+	_, err := announce(t, context.Background(), cfg, server.URL+"/announce", infoHash, "started")
+	require.NoError(t, err)
+	rec.wait(t, 1)
+	_, err = announce(t, context.Background(), cfg, server.URL+"/announce", infoHash, "")
+	require.NoError(t, err)
+
+	// Transmission sends back the tracker id of the response.
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"", "abc"}, trackerIDs)
+}
+
+func TestInstall_RetryBackoff(t *testing.T) {
+	server := newTestTracker(t, httptest.NewServer)
+	rec := &announceRecorder{}
+	cfg := torrent.NewDefaultClientConfig()
+	Install(cfg, NewDirectors(transmission.New(transmission.WithoutScrape()), rec))
+	const infoHash = "%A9%BFz%B1%BB%05%91%9A%23J5%13Y%95%14%89f%08_9"
+
+	_, err := announce(t, context.Background(), cfg, server.URL+"/down/announce", infoHash, "started")
+	require.NoError(t, err)
+	rec.wait(t, 1)
+
+	// Transmission retries the failed tracker after 20s.
+	_, err = announce(t, context.Background(), cfg, server.URL+"/down/announce", infoHash, "")
+	assert.ErrorIs(t, err, transmission.ErrTooSoon)
+}
+
+func TestInstall_HTTPS(t *testing.T) {
+	server := newTestTracker(t, httptest.NewTLSServer)
+	rec := &announceRecorder{}
+	cfg := torrent.NewDefaultClientConfig()
+	Install(cfg, rec)
+
+	body, err := announce(t, context.Background(), cfg, server.URL+"/announce", "hash1", "")
+	require.NoError(t, err)
+	assert.Equal(t, testAnnounceResponse, body)
+	assert.Never(t, func() bool {
+		rec.mu.Lock()
+		defer rec.mu.Unlock()
+		return len(rec.observed) > 0
+	}, 100*time.Millisecond, 10*time.Millisecond, "HTTPS responses are encrypted")
+}
+
+func TestInstall_NotObserver(t *testing.T) {
+	server := newTestTracker(t, httptest.NewServer)
+	cfg := torrent.NewDefaultClientConfig()
+	Install(cfg, utorrent.New())
+
+	body, err := announce(t, context.Background(), cfg, server.URL+"/announce", "hash1", "")
+	require.NoError(t, err)
+	assert.Equal(t, testAnnounceResponse, body)
+}
+
+// dialRecorder records the addresses its announces are sent to.
+type dialRecorder struct {
+	mu     sync.Mutex
+	dialed []string
+}
+
+func (d *dialRecorder) ChangeHttpRequest(req *http.Request) error {
+	commons.OnDial(req, func(conn net.Conn) {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.dialed = append(d.dialed, conn.RemoteAddr().String())
+	})
+	return nil
+}
+
+func TestInstall_OnDial(t *testing.T) {
+	server := newTestTracker(t, httptest.NewServer)
+	rec := &dialRecorder{}
+	cfg := torrent.NewDefaultClientConfig()
+	Install(cfg, rec)
+
+	_, err := announce(t, context.Background(), cfg, server.URL+"/announce", "hash1", "")
+	require.NoError(t, err)
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	assert.Equal(t, []string{server.Listener.Addr().String()}, rec.dialed)
+}
+
+func TestSwitcher_ObserveAnnounce(t *testing.T) {
+	server := newTestTracker(t, httptest.NewServer)
+	def := &announceRecorder{}
+	switched := &announceRecorder{}
+	s := NewSwitcher(def, torrent.NewDefaultClientConfig())
+	require.NoError(t, s.SwitchTracker(context.Background(), server.URL+"/b/announce", switched))
+
+	cfg := torrent.NewDefaultClientConfig()
+	Install(cfg, s)
+	ctx := context.Background()
+	_, err := announce(t, ctx, cfg, server.URL+"/a/announce", "hash1", "")
+	require.NoError(t, err)
+	_, err = announce(t, ctx, cfg, server.URL+"/b/announce", "hash1", "")
+	require.NoError(t, err)
+
+	observed := def.wait(t, 1)
+	assert.Equal(t, server.URL+"/a/announce", observed[0].url)
+	observed = switched.wait(t, 1)
+	assert.Equal(t, server.URL+"/b/announce", observed[0].url)
+
+	// Responses of switching announces go to both profiles, hash1 and hash2
+	// stop with def and start with switched.
+	req, err := http.NewRequest("GET", server.URL+"/a/announce"+announceQuery("hash2", "started"), nil)
+	require.NoError(t, err)
+	require.NoError(t, s.ChangeHttpRequest(req))
+	require.NoError(t, s.SwitchTracker(ctx, server.URL+"/a/announce", switched))
+	observed = def.wait(t, 3)
+	for _, o := range observed[1:] {
+		assert.Equal(t, server.URL+"/a/announce", o.url)
+		assert.Equal(t, "abc", o.resp.TrackerID)
+	}
+	observed = switched.wait(t, 3)
+	for _, o := range observed[1:] {
+		assert.Equal(t, server.URL+"/a/announce", o.url)
+	}
+
+	// A response goes to the profile rewrote the request, even if switched
+	// before the response.
+	req, err = http.NewRequest("GET", server.URL+"/c/announce"+announceQuery("hash3", "started"), nil)
+	require.NoError(t, err)
+	require.NoError(t, cfg.HttpRequestDirector(req))
+	s.mu.Lock()
+	s.trackers[server.URL+"/c/announce"] = switched
+	s.mu.Unlock()
+	_, err = send(cfg, req)
+	require.NoError(t, err)
+	observed = def.wait(t, 4)
+	assert.Equal(t, server.URL+"/c/announce", observed[3].url)
+	switched.wait(t, 3)
+}
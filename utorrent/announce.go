@@ -0,0 +1,76 @@
+package utorrent
+
+import (
+	"fmt"
+
+	"github.com/charleshuang3/camouflagetorrentclients/commons"
+)
+
+const (
+	utorrentV355Bep20 = "-UT355W-"
+	utorrentV355Build = "45852"
+)
+
+var (
+	headers = []commons.Header{
+		{Name: "User-Agent", Value: "uTorrent/355(" + utorrentV355Build + ")"},
+		{Name: "Accept-Encoding", Value: "gzip"},
+	}
+
+	// utorrent announces in the same fixed order and format as the µTorrent 3.5.5
+	// (Windows) BitTorrent client.
+	//
+	// µTorrent is closed source, the order follows what µTorrent 3.5.5 sends on the wire:
+	//
+	// info_hash, peer_id, port, uploaded, downloaded, left, corrupt, key, event, numwant, compact, no_peer_id
+	//
+	// µTorrent sends numwant=200 when stopping as well, and does not send
+	// "supportcrypto". anacrolix/torrent does not track corrupt bytes.
+	utorrent = &commons.ClientDef{
+		Name: "utorrent",
+		Client: commons.ClientIdentity{
+			Bep20:                          utorrentV355Bep20,
+			ExtendedHandshakeClientVersion: "µTorrent 3.5.5",
+			HTTPUserAgent:                  headers[0].Value,
+			UpnpID:                         "uTorrent",
+		},
+		Headers: headers,
+		QueryDefs: []*commons.QueryDef{
+			commons.MustHaveDef("info_hash"),
+			commons.MustHaveDef("peer_id"),
+			commons.MustHaveDef("port"),
+			commons.MustHaveDef("uploaded"),
+			commons.MustHaveDef("downloaded"),
+			commons.MustHaveDef("left"),
+			commons.FixedDef("corrupt", "0"),
+			commons.MustHaveDef("key"),
+			commons.OptionalDef("event"),
+			commons.MustHaveDef("numwant"),
+			commons.MustHaveDef("compact"),
+			commons.FixedDef("no_peer_id", "1"),
+		},
+		NumWant:     commons.NumWant{Announce: 200, Stopped: 200},
+		Scopes:      commons.Scopes{PeerID: commons.ScopeSession, Key: commons.ScopeSession},
+		NewIdentity: createPerTorrent,
+	}
+)
+
+// New creates a director announcing as µTorrent 3.5.5.
+func New() *commons.ClientDirector {
+	s, err := commons.NewClientDirector(utorrent)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func createPerTorrent() *commons.Identity {
+	// µTorrent peer_id is "-UT355W-" + 12 random bytes, not limited to printable
+	// chars. Per session, see ConfigureClient.
+
+	// key is random uint32 in 08X format. Per session.
+	return &commons.Identity{
+		PeerID: utorrentV355Bep20 + string(commons.RandomBytes(12)),
+		Key:    fmt.Sprintf("%08X", commons.RandomUint32()),
+	}
+}